@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"snippetbox.felipeacosta.net/internal/models"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// How many rows to show on each page of the admin lists, and how many audit
+// events to show on the dashboard.
+const (
+	adminPageSize    = 25
+	adminAuditEvents = 20
+)
+
+// The adminDashboard handler shows the counts of users and snippets, and the
+// most recent audit events.
+func (app *application) adminDashboard(w http.ResponseWriter, r *http.Request) {
+	userCounts, err := app.users.Counts()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	snippetCounts, err := app.snippets.Counts()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	events, err := app.audit.Recent(adminAuditEvents)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.UserCounts = userCounts
+	data.SnippetCounts = snippetCounts
+	data.AuditEvents = events
+	app.render(w, r, http.StatusOK, "admin.tmpl.html", data)
+}
+
+// The adminUsers handler lists the users, newest first. The ?q= parameter
+// searches their names and email addresses.
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	search, page := readSearch(r), readPage(r)
+
+	users, total, err := app.users.List(search, page, adminPageSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Users = users
+	data.Search = search
+	data.Pagination = newPagination(page, adminPageSize, total, searchQuery(search))
+	app.render(w, r, http.StatusOK, "admin_users.tmpl.html", data)
+}
+
+// The adminSnippets handler lists all the snippets, including expired and
+// hidden ones, newest first. The ?q= parameter searches their titles and
+// content.
+func (app *application) adminSnippets(w http.ResponseWriter, r *http.Request) {
+	search, page := readSearch(r), readPage(r)
+
+	snippets, total, err := app.snippets.List(search, page, adminPageSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	data.Search = search
+	data.Pagination = newPagination(page, adminPageSize, total, searchQuery(search))
+	app.render(w, r, http.StatusOK, "admin_snippets.tmpl.html", data)
+}
+
+// searchQuery returns the query string parameters for a search, for the links
+// to the other pages of the results.
+func searchQuery(search string) url.Values {
+	q := url.Values{}
+	if search != "" {
+		q.Set("q", search)
+	}
+	return q
+}
+
+// Every admin form has a hidden return_to field holding the page it was on
+// (including the search and page number), so that the admin is sent back
+// there afterwards.
+type adminActionForm struct {
+	ReturnTo string `form:"return_to"`
+}
+
+// The adminAction() helper does the work which is common to every admin and
+// moderation action. It reads the ID from the URL and the form, calls do()
+// with the ID, records the action in the audit table, and then redirects back
+// with a flash message. If do() returns models.ErrNoRecord, the user or
+// snippet doesn't exist and the response is a 404; if it returns an
+// adminRefusal, nothing was done.
+func (app *application) adminAction(w http.ResponseWriter, r *http.Request, action, targetType, flash string, do func(id int) error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	var form adminActionForm
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// Only send the admin back to a page in the same area as the action (the
+	// admin pages for /admin/..., the moderation pages for /moderation/...).
+	// Anything else in the form would make this an open redirect.
+	area, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	returnTo := "/" + area
+	if safeRedirectPath(form.ReturnTo) && strings.HasPrefix(form.ReturnTo, returnTo) {
+		returnTo = form.ReturnTo
+	}
+
+	err = do(id)
+	if err != nil {
+		var refusal adminRefusal
+		switch {
+		case errors.As(err, &refusal):
+			app.sessionManager.Put(r.Context(), "flash", string(refusal))
+			http.Redirect(w, r, returnTo, http.StatusSeeOther)
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w, r)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.recordAudit(r, action, targetType, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf(flash, id))
+	http.Redirect(w, r, returnTo, http.StatusSeeOther)
+}
+
+// An adminRefusal is returned by an admin action which isn't allowed. The
+// action isn't recorded, and the message is shown to the admin instead.
+type adminRefusal string
+
+func (e adminRefusal) Error() string {
+	return string(e)
+}
+
+func (app *application) adminUserDisablePost(w http.ResponseWriter, r *http.Request) {
+	app.adminAction(w, r, "admin.user.disable", "user", "User #%d has been disabled.", func(id int) error {
+		// Disabling their own account would lock the admin out of the admin area.
+		if id == app.sessionManager.GetInt(r.Context(), "authenticatedUserID") {
+			return adminRefusal("You can't disable your own account.")
+		}
+
+		err := app.users.SetDisabled(id, true)
+		if err != nil {
+			return err
+		}
+
+		// Log the user out everywhere straight away.
+		return app.destroyUserSessions(r.Context(), id)
+	})
+}
+
+func (app *application) adminUserEnablePost(w http.ResponseWriter, r *http.Request) {
+	app.adminAction(w, r, "admin.user.enable", "user", "User #%d has been enabled.", func(id int) error {
+		return app.users.SetDisabled(id, false)
+	})
+}
+
+// Moderators can work through the moderation queue. Admins can make any user
+// who isn't an admin a moderator, and take it away again. Admins themselves
+// are only made with the -admin-email flag, so they can't be changed here.
+func (app *application) adminUserGrantModeratorPost(w http.ResponseWriter, r *http.Request) {
+	app.adminAction(w, r, "admin.user.moderator.grant", "user", "User #%d is now a moderator.", func(id int) error {
+		return app.setModerator(id, models.RoleModerator)
+	})
+}
+
+func (app *application) adminUserRevokeModeratorPost(w http.ResponseWriter, r *http.Request) {
+	app.adminAction(w, r, "admin.user.moderator.revoke", "user", "User #%d is no longer a moderator.", func(id int) error {
+		return app.setModerator(id, models.RoleUser)
+	})
+}
+
+// The setModerator() helper changes a user's role between user and moderator,
+// for the two actions above.
+func (app *application) setModerator(id int, role models.Role) error {
+	user, err := app.users.Get(id)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case user.Role == models.RoleAdmin:
+		return adminRefusal(fmt.Sprintf("User #%d is an admin.", id))
+	case user.Role == role:
+		if role == models.RoleModerator {
+			return adminRefusal(fmt.Sprintf("User #%d is already a moderator.", id))
+		}
+		return adminRefusal(fmt.Sprintf("User #%d isn't a moderator.", id))
+	}
+
+	return app.users.SetRole(id, role)
+}
+
+func (app *application) adminSnippetHidePost(w http.ResponseWriter, r *http.Request) {
+	app.adminAction(w, r, "admin.snippet.hide", "snippet", "Snippet #%d has been hidden.", func(id int) error {
+		return app.snippets.SetHidden(id, true, models.HiddenByAdmin)
+	})
+}
+
+func (app *application) adminSnippetUnhidePost(w http.ResponseWriter, r *http.Request) {
+	app.adminAction(w, r, "admin.snippet.unhide", "snippet", "Snippet #%d is no longer hidden.", func(id int) error {
+		return app.snippets.SetHidden(id, false, "")
+	})
+}
+
+func (app *application) adminSnippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	app.adminAction(w, r, "admin.snippet.delete", "snippet", "Snippet #%d has been deleted.", func(id int) error {
+		return app.snippets.Delete(id)
+	})
+}
+
+// The promoteAdmin() method makes the user with the given email address an
+// admin. It's run at startup for the -admin-email flag.
+func (app *application) promoteAdmin(email string) error {
+	user, err := app.users.GetByEmail(email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.errorLog.Printf("WARNING: -admin-email: no user with the email address %q", email)
+			return nil
+		}
+		return err
+	}
+
+	if user.Role == models.RoleAdmin {
+		return nil
+	}
+
+	err = app.users.SetRole(user.ID, models.RoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	app.auditLog.Printf("promoted user #%d to admin (-admin-email)", user.ID)
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+
+	"snippetbox.felipeacosta.net/internal/models"
+)
+
+// How many events to show in the "recent security activity" list on the
+// account page.
+const accountAuditEvents = 10
+
+// The recordAudit() helper adds an event to the audit table, saying that the
+// logged-in user did something to the given target.
+func (app *application) recordAudit(r *http.Request, action, targetType string, targetID int) error {
+	return app.recordAuditAs(r, app.sessionManager.GetInt(r.Context(), "authenticatedUserID"), action, targetType, targetID)
+}
+
+// The recordAuditAs() helper is the same, but for the events where the user
+// who did it isn't (or isn't yet) the logged-in user, like signing up. A
+// userID of 0 means someone who isn't logged in.
+func (app *application) recordAuditAs(r *http.Request, userID int, action, targetType string, targetID int) error {
+	return app.audit.Insert(&models.AuditEvent{
+		UserID:     userID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		IP:         app.clientIP(r),
+		RequestID:  app.requestID(r),
+	})
+}
+
+// auditDescriptions describes the audit actions for the account page, from
+// the point of view of the user the event concerns.
+var auditDescriptions = map[string]string{
+	"user.signup":                 "Signed up",
+	"user.login":                  "Logged in",
+	"user.login.failure":          "Failed login attempt",
+	"user.logout":                 "Logged out",
+	"user.password.change":        "Changed password",
+	"user.password.reset":         "Reset password",
+	"user.2fa.enable":             "Turned on two-factor authentication",
+	"user.2fa.disable":            "Turned off two-factor authentication",
+	"user.delete":                 "Deleted account",
+	"snippet.create":              "Created a snippet",
+	"snippet.extend":              "Extended a snippet",
+	"snippet.delete":              "Deleted a snippet",
+	"admin.user.disable":          "Account disabled by an admin",
+	"admin.user.enable":           "Account enabled by an admin",
+	"admin.user.moderator.grant":  "Made a moderator by an admin",
+	"admin.user.moderator.revoke": "Moderator role removed by an admin",
+}
+
+// describeAudit returns the description of an audit action, or the action
+// itself if it doesn't have one.
+func describeAudit(action string) string {
+	if description, ok := auditDescriptions[action]; ok {
+		return description
+	}
+	return action
+}
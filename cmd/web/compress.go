@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// The content codings we know how to produce, in order of preference.
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+// negotiateEncoding picks the best content coding we support from the value of
+// an Accept-Encoding request header. Brotli is preferred over gzip when the
+// client rates them equally. An empty string means the response should be
+// sent uncompressed.
+func negotiateEncoding(acceptEncoding string) string {
+	var best string
+	var bestQ float64
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		// A q-value of zero means "not acceptable".
+		if q <= 0 {
+			continue
+		}
+
+		switch coding {
+		case encodingBrotli, encodingGzip:
+		case "*":
+			coding = encodingBrotli
+		default:
+			continue
+		}
+
+		if q > bestQ || (q == bestQ && coding == encodingBrotli) {
+			best, bestQ = coding, q
+		}
+	}
+
+	return best
+}
+
+// isCompressible reports whether a response with the given Content-Type is
+// worth compressing. Images, audio, video, fonts and archives are (almost
+// always) compressed already, so running them through gzip or brotli again
+// just burns CPU. SVG images and unknown types are plain text, so they are
+// compressed.
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType == ""
+	}
+
+	switch {
+	case mediaType == "image/svg+xml":
+		return true
+	case strings.HasPrefix(mediaType, "image/"),
+		strings.HasPrefix(mediaType, "audio/"),
+		strings.HasPrefix(mediaType, "video/"),
+		strings.HasPrefix(mediaType, "font/"):
+		return false
+	}
+
+	switch mediaType {
+	case "application/zip", "application/gzip", "application/x-gzip",
+		"application/x-brotli", "application/x-bzip2", "application/x-xz",
+		"application/zstd", "application/pdf", "application/octet-stream":
+		return false
+	}
+
+	return true
+}
+
+// Pools of compressors, so that we don't allocate a fresh (and fairly large)
+// compressor for every response.
+var (
+	gzipWriterPool = sync.Pool{
+		New: func() any { return gzip.NewWriter(io.Discard) },
+	}
+	brotliWriterPool = sync.Pool{
+		New: func() any { return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression) },
+	}
+)
+
+// compressWriter wraps a http.ResponseWriter and compresses the body with the
+// negotiated content coding. It holds back the first minSize bytes of the
+// body, so that it can decide whether compressing is worthwhile before any
+// headers are sent.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status      int
+	buf         []byte
+	decided     bool
+	compressing bool
+	encoder     io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	// Informational responses are sent straight away and don't affect the
+	// final response.
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if cw.decided {
+		if cw.compressing {
+			return cw.encoder.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	// Keep buffering until we have enough of the body to make a decision.
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) < cw.minSize {
+		return len(b), nil
+	}
+
+	if err := cw.decide(); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// decide works out whether to compress the response, sends the headers, and
+// then flushes whatever has been buffered so far.
+func (cw *compressWriter) decide() error {
+	cw.decided = true
+
+	h := cw.Header()
+
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	// A 304 response stands in for the 200 response the client would
+	// otherwise get, so it needs the same ETag: a weakened one if that 200
+	// would have been compressed. There's no body to go on, so we rely on the
+	// handler. Handlers whose 200 responses we'd compress set the same
+	// Content-Type on their 304s as on their 200s; ones which don't set one (like
+	// http.ServeContent(), which also sends already-encoded files) keep their
+	// ETags as they are. This has to be checked before the Content-Type is
+	// sniffed below.
+	if cw.status == http.StatusNotModified &&
+		h.Get("Content-Type") != "" &&
+		h.Get("Content-Encoding") == "" &&
+		isCompressible(h.Get("Content-Type")) {
+		weakenETag(h)
+	}
+
+	// Fill in the Content-Type now (like the standard library would on the
+	// first write), so that we can check it.
+	if _, ok := h["Content-Type"]; !ok && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	cw.compressing = len(cw.buf) >= cw.minSize &&
+		len(cw.buf) > 0 &&
+		h.Get("Content-Encoding") == "" &&
+		cw.status != http.StatusNoContent &&
+		cw.status != http.StatusNotModified &&
+		cw.status != http.StatusPartialContent &&
+		isCompressible(h.Get("Content-Type"))
+
+	if cw.compressing {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+
+		weakenETag(h)
+
+		switch cw.encoding {
+		case encodingBrotli:
+			bw := brotliWriterPool.Get().(*brotli.Writer)
+			bw.Reset(cw.ResponseWriter)
+			cw.encoder = bw
+		default:
+			gw := gzipWriterPool.Get().(*gzip.Writer)
+			gw.Reset(cw.ResponseWriter)
+			cw.encoder = gw
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.compressing {
+		_, err = cw.encoder.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// addVary adds a field name to the Vary header, unless it is already there.
+func addVary(h http.Header, field string) {
+	for _, v := range h.Values("Vary") {
+		for _, existing := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), field) {
+				return
+			}
+		}
+	}
+
+	h.Add("Vary", field)
+}
+
+// weakenETag turns a strong ETag into a weak one. The compressed body isn't
+// byte-for-byte the same as the original representation, so it can't keep
+// the strong ETag which the handler set.
+func weakenETag(h http.Header) {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+}
+
+// close must be called once the handler has returned. It sends anything which
+// is still buffered and finishes off the compressed stream.
+func (cw *compressWriter) close() error {
+	// If the handler never wrote anything at all, let the standard library
+	// send its default response.
+	if !cw.decided && cw.status == 0 && len(cw.buf) == 0 {
+		return nil
+	}
+
+	if !cw.decided {
+		if err := cw.decide(); err != nil {
+			return err
+		}
+	}
+
+	if !cw.compressing {
+		return nil
+	}
+
+	err := cw.encoder.Close()
+
+	switch enc := cw.encoder.(type) {
+	case *brotli.Writer:
+		brotliWriterPool.Put(enc)
+	case *gzip.Writer:
+		gzipWriterPool.Put(enc)
+	}
+	cw.encoder = nil
+
+	return err
+}
+
+// Flush sends any buffered data to the client, which means making the
+// compression decision early if it hasn't been made yet.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if err := cw.decide(); err != nil {
+			return
+		}
+	}
+
+	if f, ok := cw.encoder.(interface{ Flush() error }); ok && cw.compressing {
+		f.Flush()
+	}
+
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets websocket-style handlers take over the connection, in which case
+// there is nothing left for us to compress.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	cw.decided = true
+	return hj.Hijack()
+}
+
+// Unwrap returns the original http.ResponseWriter, so that
+// http.ResponseController can reach it.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// The compress() middleware compresses response bodies with brotli or gzip,
+// depending on what the client says it accepts. Responses smaller than the
+// configured minimum size, responses which are already encoded, and types
+// which are already compressed are passed through untouched.
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Whether or not we end up compressing this particular response, the
+		// response to this URL depends on the Accept-Encoding header, so any
+		// caches in between need to know that.
+		addVary(w.Header(), "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+
+		// There's no body to compress for HEAD requests, and byte ranges refer
+		// to the uncompressed representation, so leave those alone too.
+		if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			encoding:       encoding,
+			minSize:        app.config.compressMinSize,
+		}
+
+		next.ServeHTTP(cw, r)
+
+		// This deliberately isn't deferred. If the handler panics we want the
+		// buffered part of the body to be thrown away, so that recoverPanic can
+		// still send a clean 500 response.
+		if err := cw.close(); err != nil {
+			app.errorLog.Print(err)
+		}
+	})
+}
@@ -4,4 +4,14 @@ type contextKey string
 
 const isAuthenticatedContextKey = contextKey("isAuthenticated")
 
+// userRoleContextKey holds the models.Role of the authenticated user.
+const userRoleContextKey = contextKey("userRole")
 
+
+// requestIDContextKey holds the ID of the request, for the logs and the audit
+// events.
+const requestIDContextKey = contextKey("requestID")
+
+// limitedBodyContextKey holds the *limitedBody which the limitBody middleware
+// wrapped around the request body.
+const limitedBodyContextKey = contextKey("limitedBody")
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// devTemplates is used instead of the embedded template cache when the
+// application is started with the -dev flag. It parses the templates from the
+// ui folder on disk, and parses them again whenever any of the files under
+// html/ change, so template tweaks show up on the next page load without a
+// rebuild or restart.
+type devTemplates struct {
+	fsys fs.FS
+
+	mu    sync.Mutex
+	stamp string
+	cache map[string]*template.Template
+	err   error
+}
+
+// newDevTemplates returns a devTemplates which reads from fsys (normally
+// os.DirFS("./ui")). The templates are parsed lazily on the first request.
+func newDevTemplates(fsys fs.FS) *devTemplates {
+	return &devTemplates{fsys: fsys}
+}
+
+// get returns the current template cache, reparsing the templates first if
+// anything has changed since they were last parsed. If the templates don't
+// parse, the error is returned (and returned again on every call until the
+// files are fixed).
+func (d *devTemplates) get() (map[string]*template.Template, error) {
+	stamp, err := d.snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if stamp != d.stamp {
+		d.cache, d.err = parseTemplates(d.fsys)
+		d.stamp = stamp
+	}
+
+	return d.cache, d.err
+}
+
+// snapshot walks the html folder and returns a string which changes whenever
+// a file is added, removed or modified. The folder only holds a handful of
+// small files, so doing this on every request is cheap enough for
+// development.
+func (d *devTemplates) snapshot() (string, error) {
+	var stamp string
+
+	err := fs.WalkDir(d.fsys, "html", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		stamp += fmt.Sprintf("%s:%d:%d;", name, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+
+	return stamp, err
+}
+
+// devTemplateErrorPage is deliberately self-contained: it's used when the
+// application's own templates are broken, so it can't rely on them.
+var devTemplateErrorPage = template.Must(template.New("template-error").Parse(`<!doctype html>
+<html lang="en">
+    <head>
+        <meta charset="utf-8">
+        <title>Template error - Snippetbox</title>
+        <style>
+            body { font-family: sans-serif; margin: 2em; color: #34495E; }
+            h1 { color: #C0392B; }
+            pre { background: #F7F9FA; border: 1px solid #E4E5E7; padding: 1em; white-space: pre-wrap; }
+        </style>
+    </head>
+    <body>
+        <h1>Template error</h1>
+        <p>The page <code>{{.Page}}</code> could not be rendered at {{.Time}}. Fix the template and reload the page.</p>
+        <pre>{{.Err}}</pre>
+    </body>
+</html>
+`))
+
+// devTemplateError logs a template error and sends it to the browser as a
+// readable HTML page. It must only be used in development mode, because the
+// error messages contain details about the application's files.
+func (app *application) devTemplateError(w http.ResponseWriter, page string, err error) {
+	app.errorLog.Output(2, err.Error())
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusInternalServerError)
+
+	devTemplateErrorPage.Execute(w, map[string]any{
+		"Page": page,
+		"Time": time.Now().Format("15:04:05"),
+		"Err":  err.Error(),
+	})
+}
+
+// debugErrorPageTemplate is used for the detailed error pages shown when the
+// application is started with -debug. Like devTemplateErrorPage it doesn't
+// depend on the application's own templates.
+var debugErrorPageTemplate = template.Must(template.New("debug-error").Parse(`<!doctype html>
+<html lang="en">
+    <head>
+        <meta charset="utf-8">
+        <title>{{.Status}} - Snippetbox debug</title>
+        <style>
+            body { font-family: sans-serif; margin: 2em; color: #34495E; }
+            h1 { color: #C0392B; }
+            h2 { margin-top: 1.5em; }
+            pre { background: #F7F9FA; border: 1px solid #E4E5E7; padding: 1em; overflow-x: auto; }
+            table { border-collapse: collapse; }
+            th, td { text-align: left; padding: 0.25em 1em 0.25em 0; vertical-align: top; font-family: monospace; }
+        </style>
+    </head>
+    <body>
+        <h1>{{.Status}}</h1>
+        <p>This page is only shown because the application is running with <code>-debug</code>.</p>
+
+        <h2>Error chain</h2>
+        <ol>
+            {{range .Chain}}<li><code>{{.Type}}</code>: {{.Message}}</li>{{end}}
+        </ol>
+
+        <h2>Stack trace</h2>
+        <pre>{{.Stack}}</pre>
+
+        <h2>Request</h2>
+        <table>
+            <tr><th>Method</th><td>{{.Request.Method}}</td></tr>
+            <tr><th>URL</th><td>{{.Request.URL.RequestURI}}</td></tr>
+            <tr><th>Protocol</th><td>{{.Request.Proto}}</td></tr>
+            <tr><th>Remote address</th><td>{{.Request.RemoteAddr}}</td></tr>
+        </table>
+
+        <h2>Request headers</h2>
+        <table>
+            {{range $name, $value := .Headers}}<tr><th>{{$name}}</th><td>{{$value}}</td></tr>{{end}}
+        </table>
+
+        <h2>Session keys</h2>
+        {{if .SessionLoaded}}
+            {{with .SessionKeys}}
+                <ul>{{range .}}<li><code>{{.}}</code></li>{{end}}</ul>
+            {{else}}
+                <p>The session is empty.</p>
+            {{end}}
+        {{else}}
+            <p>The session hadn't been loaded when the error happened.</p>
+        {{end}}
+    </body>
+</html>
+`))
+
+// errorChainLink is one error in the chain shown on the debug error page.
+type errorChainLink struct {
+	Type    string
+	Message string
+}
+
+// errorChain flattens an error and everything it wraps into a list, outermost
+// first. Errors which wrap several others (like those built by errors.Join)
+// have each branch followed in turn.
+func errorChain(err error) []errorChainLink {
+	var chain []errorChainLink
+
+	var walk func(error)
+	walk = func(err error) {
+		if err == nil {
+			return
+		}
+
+		chain = append(chain, errorChainLink{Type: fmt.Sprintf("%T", err), Message: err.Error()})
+
+		switch e := err.(type) {
+		case interface{ Unwrap() error }:
+			walk(e.Unwrap())
+		case interface{ Unwrap() []error }:
+			for _, inner := range e.Unwrap() {
+				walk(inner)
+			}
+		}
+	}
+	walk(err)
+
+	return chain
+}
+
+// sessionKeys returns the keys (but not the values) in the request's session.
+// If the session hasn't been loaded into the request context (for example,
+// because the error happened in middleware which runs before LoadAndSave) the
+// session manager panics, which we turn into ok == false.
+func (app *application) sessionKeys(r *http.Request) (keys []string, ok bool) {
+	defer func() {
+		if recover() != nil {
+			keys, ok = nil, false
+		}
+	}()
+
+	return app.sessionManager.Keys(r.Context()), true
+}
+
+// debugErrorPage sends a detailed HTML error page with the error chain, stack
+// trace, request details and session keys. It is only called when the
+// application is running with -debug.
+func (app *application) debugErrorPage(w http.ResponseWriter, r *http.Request, err error, stack []byte) {
+	// Don't echo back credentials, even on a debug page.
+	headers := map[string]string{}
+	for name, values := range r.Header {
+		switch name {
+		case "Authorization", "Cookie":
+			headers[name] = "[redacted]"
+		default:
+			headers[name] = strings.Join(values, ", ")
+		}
+	}
+
+	keys, loaded := app.sessionKeys(r)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusInternalServerError)
+
+	debugErrorPageTemplate.Execute(w, map[string]any{
+		"Status":        fmt.Sprintf("%d %s", http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError)),
+		"Chain":         errorChain(err),
+		"Stack":         string(stack),
+		"Request":       r,
+		"Headers":       headers,
+		"SessionLoaded": loaded,
+		"SessionKeys":   keys,
+	})
+}
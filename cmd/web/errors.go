@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// errorMessages holds the friendly explanation shown on the error page for
+// each status code. Anything not listed here just gets the status text.
+var errorMessages = map[int]string{
+	http.StatusBadRequest:            "Your request couldn't be understood. Please go back and try again.",
+	http.StatusForbidden:             "You don't have permission to access this page.",
+	http.StatusNotFound:              "The page you were looking for doesn't exist. It may have expired or been deleted.",
+	http.StatusMethodNotAllowed:      "That request method isn't supported for this page.",
+	http.StatusRequestEntityTooLarge: "That's more than we can accept in one go. If you were creating a snippet, please make it shorter.",
+	http.StatusTooManyRequests:       "You're making requests too quickly. Please wait a moment and try again.",
+	http.StatusInternalServerError:   "Sorry, something went wrong on our end. Please try again later.",
+}
+
+// errorMessage returns the friendly message for a status code.
+func errorMessage(status int) string {
+	if message, ok := errorMessages[status]; ok {
+		return message
+	}
+	return http.StatusText(status)
+}
+
+// wantsJSON reports whether the client would rather have a JSON error body
+// than an HTML page. That's the case for anything under /api/, and for
+// clients which list application/json ahead of text/html in their Accept
+// header.
+func wantsJSON(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		return true
+	}
+
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		switch mediaType {
+		case "application/json":
+			return true
+		case "text/html", "application/xhtml+xml":
+			return false
+		}
+	}
+
+	return false
+}
+
+// The errorResponse() helper sends an error response with the given status
+// code, either as JSON or as an HTML page rendered with the normal base layout
+// and navigation.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int) {
+	message := errorMessage(status)
+
+	if wantsJSON(r) {
+		js, err := json.Marshal(map[string]string{"error": message})
+		if err != nil {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(append(js, '\n'))
+		return
+	}
+
+	// Use the same template data as every other page, so that the navigation
+	// has the same links. newTemplateData() copes with the session not having
+	// been loaded yet, as it won't have been for a panic in the middleware.
+	data := app.newTemplateData(r)
+	data.ErrorStatus = status
+	data.ErrorTitle = http.StatusText(status)
+	data.ErrorMessage = message
+
+	// We can't use render() here, because render() reports its own failures
+	// through serverError(), which would bring us straight back here. If the
+	// error page itself can't be rendered, fall back to a plain-text response.
+	buf := new(bytes.Buffer)
+	if err := app.renderErrorPage(buf, data); err != nil {
+		app.errorLog.Output(2, err.Error())
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// renderErrorPage executes the error page template into buf, using the
+// templates from disk in development mode.
+func (app *application) renderErrorPage(buf *bytes.Buffer, data *templateData) error {
+	templateCache := app.templateCache
+	if app.devTemplates != nil {
+		var err error
+		templateCache, err = app.devTemplates.get()
+		if err != nil {
+			return err
+		}
+	}
+
+	ts, ok := templateCache["error.tmpl.html"]
+	if !ok {
+		return fmt.Errorf("the template %s does not exist", "error.tmpl.html")
+	}
+
+	return ts.ExecuteTemplate(buf, "base", data)
+}
+
+// The methodNotAllowed() helper sends a 405 Method Not Allowed response.
+// httprouter has already set the Allow header by the time it's called.
+func (app *application) methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	app.clientError(w, r, http.StatusMethodNotAllowed)
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
+	"snippetbox.felipeacosta.net/internal/mailer"
 	"snippetbox.felipeacosta.net/internal/models"
 	"snippetbox.felipeacosta.net/internal/validator"
 
@@ -15,14 +17,14 @@ import (
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	snippets, err := app.snippets.Latest()
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
 	data := app.newTemplateData(r)
 	data.Snippets = snippets
 
-	app.render(w, http.StatusOK, "home.tmpl.html", data)
+	app.render(w, r, http.StatusOK, "home.tmpl.html", data)
 }
 
 func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
@@ -34,30 +36,60 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 	// parameter form the slice and validate it as normal.
 	id, err := strconv.Atoi(params.ByName("id"))
 	if err != nil || id < 1 {
-		app.notFound(w)
+		app.notFound(w, r)
 		return
 	}
 
 	snippet, err := app.snippets.Get(id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
-			app.notFound(w)
+			app.notFound(w, r)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
 
+	// Snippets rarely change, so anonymous viewers get a strong ETag and can
+	// revalidate their cached copy with a conditional GET instead of downloading
+	// the whole page again. Pages for logged-in users contain a CSRF token and
+	// per-user navigation, so they keep the same 'no-store' behaviour as the
+	// protected routes. We also skip the ETag when there is a flash message
+	// waiting, because that would change the page without changing the snippet.
+	if app.isAuthenticated(r) {
+		w.Header().Add("Cache-Control", "no-store")
+	} else if !app.sessionManager.Exists(r.Context(), "flash") {
+		etag := snippetETag(snippet, app.pageVersion)
+
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "private, no-cache")
+		w.Header().Add("Vary", "Cookie")
+
+		if etagMatch(r.Header.Get("If-None-Match"), etag) {
+			// Say what type the page is, like the 200 response would, so that the compress
+			// middleware can give the 304 the same ETag.
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
 	// Use the PopString() method to retieve the value for the "flash" key. Popstring() also deletes the key and value from the session data, so it acts like one-time fetch. If there is no matching key in the session data this will return the empty string.
 	// flash := app.sessionManager.PopString(r.Context(), "flash")	// Adding in helpers.go newTemplateData func ... Flash: app.session... means we no longer need to check for the flash message within here.
 
 	data := app.newTemplateData(r)
 	data.Snippet = snippet
 
+	// Logged-in users can report the snippet.
+	if app.isAuthenticated(r) {
+		data.Form = snippetReportForm{}
+		data.ReportReasons = models.ReportReasons
+	}
+
 	// Pass the flash message to the template.
 	// data.Flash = flash
 
-	app.render(w, http.StatusOK, "view.tmpl.html", data)
+	app.render(w, r, http.StatusOK, "view.tmpl.html", data)
 }
 
 // Add a new snippetCreate handler, which for now returns a placeholder response, We'll update this.
@@ -71,7 +103,16 @@ func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
 		Expires: 365,
 	}
 
-	app.render(w, http.StatusOK, "create.tmpl.html", data)
+	// Users who haven't verified their email address yet are shown a notice
+	// (with a button to resend the email) instead of being able to publish.
+	activated, err := app.currentUserActivated(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data.NeedsVerification = !activated
+
+	app.render(w, r, http.StatusOK, "create.tmpl.html", data)
 }
 
 // Define a snippetCreateForm struct to represent the form data and validation errors for the form fields. Note that all the struct fields are deliberately exported (i.e start with a capital letter). This is because struct fields must be exported in order to be read by the html/template package when rendering the template.
@@ -81,18 +122,34 @@ type snippetCreateForm struct {
 	Title   string `form:"title"`
 	Content string `form:"content"`
 	Expires int    `form:"expires"`
+	// ConfirmSecrets is ticked by the user to publish a snippet which the
+	// secret scanner objected to. SecretsFound shows the checkbox for it.
+	ConfirmSecrets bool `form:"confirm_secrets"`
+	SecretsFound   bool `form:"-"`
 	// FieldErrors map[string]string
 	validator.Validator `form:"-"` // completely ignore this field during decoding.
 }
 
 func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
+	// Only users who have verified their email address can publish snippets.
+	activated, err := app.currentUserActivated(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !activated {
+		app.sessionManager.Put(r.Context(), "flash", "Please verify your email address before creating snippets. Check your inbox for the link we sent you.")
+		http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+		return
+	}
+
 	// Declare a new empty instance of the snippetCreateForm struct
 	var form snippetCreateForm
 
 	// Call the Decode() method of the form decoder, passing in the current request and *a pinter* to our snippetCreateForm struct. This will essentially fill our struct with the relevant values from the HTML form. If there is a problme, we return a 400 Bad Request response client.
-	err := app.decodePostForm(r, &form)
+	err = app.decodePostForm(r, &form)
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
 
@@ -100,23 +157,40 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
 	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
 	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Content, app.config.maxSnippetLength), "content", fmt.Sprintf("This field cannot be more than %d characters long", app.config.maxSnippetLength))
 	// form.CheckField(validator.PermittedInt(form.Expires, 1, 7, 365), "expires", "This filed must be equal to 1, 7, or 365")
 	// Use the generic PermittedValue() function instead of the type-specific PermittedInt() function.
 	form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7, or 365")
 
+	// Check that the snippet doesn't contain any credentials, like cloud provider keys, which the user
+	// probably didn't mean to publish. They can publish it anyway if they confirm that it's safe.
+	if app.secretScanner != nil && !form.ConfirmSecrets {
+		findings := app.secretScanner.Scan(form.Content)
+		if len(findings) > 0 {
+			form.SecretsFound = true
+			form.AddFieldError("content", secretsMessage(findings))
+		}
+	}
+
 	// Use the Valid() method to see if any of the checks failed. If the did, then re-render the template passing in the form in the same way as before.
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "create.tmpl.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "create.tmpl.html", data)
 		return
 	}
 
 	// We also nee to update this line to pass the data from the
 	// snippetCreateForm instance to our Insert() method.
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
+	id, err := app.snippets.Insert(app.sessionManager.GetInt(r.Context(), "authenticatedUserID"), form.Title, form.Content, form.Expires)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.recordAudit(r, "snippet.create", "snippet", id)
+	if err != nil {
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -141,7 +215,7 @@ type userSignupForm struct {
 func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
 	data.Form = userSignupForm{}
-	app.render(w, http.StatusOK, "signup.tmpl.html", data)
+	app.render(w, r, http.StatusOK, "signup.tmpl.html", data)
 }
 
 func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
@@ -151,7 +225,7 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 	// Parse the form data into the userSignupForm struct.
 	err := app.decodePostForm(r, &form)
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
 
@@ -166,27 +240,41 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "signup.tmpl.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "signup.tmpl.html", data)
 		return
 	}
 
 	// Try to create a new user record in the databse. If the email already exists then add an error message to the form and re-display it.
-	err = app.users.Insert(form.Name, form.Email, form.Password)
+	id, err := app.users.Insert(form.Name, form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrDuplicateEmail) {
 			form.AddFieldError("email", "Email address is already in use")
 
 			data := app.newTemplateData(r)
 			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "signup.tmpl.html", data)
+			app.render(w, r, http.StatusUnprocessableEntity, "signup.tmpl.html", data)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
 
+	err = app.recordAuditAs(r, id, "user.signup", "user", id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Send the new user a link to verify their email address. They can log in straight away, but can't
+	// create snippets until they've followed it.
+	err = app.sendVerificationEmail(&models.User{ID: id, Name: form.Name, Email: form.Email})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	// Otherwise add a confirmation flash message to the session confirming that their signup worked.
-	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please log in.")
+	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. We've emailed you a link to verify your address. Please log in.")
 
 	// And redirect the user to the login page.
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
@@ -196,6 +284,7 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 type userLoginForm struct {
 	Email               string `form:"email"`
 	Password            string `form:"password"`
+	RememberMe          bool   `form:"remember_me"`
 	validator.Validator `form:"-"`
 }
 
@@ -203,7 +292,7 @@ type userLoginForm struct {
 func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
 	data.Form = userLoginForm{}
-	app.render(w, http.StatusOK, "login.tmpl.html", data)
+	app.render(w, r, http.StatusOK, "login.tmpl.html", data)
 }
 
 func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
@@ -212,7 +301,7 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 
 	err := app.decodePostForm(r, &form) 
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
 
@@ -224,7 +313,20 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "login.tmpl.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "login.tmpl.html", data)
+		return
+	}
+
+	// Refuse to check the password at all while the account or the client's IP address is locked out.
+	// We do this whether or not the account exists, so that the lockout doesn't give away which email
+	// addresses are registered.
+	ip := app.clientIP(r)
+	if wait := app.loginThrottle.check(form.Email, ip); wait > 0 {
+		form.AddNonFieldError(lockedOut(w, wait))
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusTooManyRequests, "login.tmpl.html", data)
 		return
 	}
 
@@ -233,42 +335,160 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 	id, err := app.users.Authenticate(form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrInvalidCredentials) {
+			app.loginFailed(form.Email, ip)
+
+			err = app.recordLoginFailure(r, form.Email)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
 			form.AddNonFieldError("Email or password is incorrect")
 
 			data := app.newTemplateData(r)
 			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "login.tmpl.html", data)
+			app.render(w, r, http.StatusUnprocessableEntity, "login.tmpl.html", data)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// An admin can disable an account. Its password still works, but it can't be used to log in. We only
+	// say so once the password has been checked, so this doesn't give away anything about the account.
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if user.Disabled {
+		app.loginDisabled(w, r)
+		return
+	}
+
+	app.startLogin(w, r, id, form.Email, form.RememberMe)
+}
+
+// The recordLoginFailure() helper records a failed login in the audit table. If the email address
+// belongs to an account, the event is about that account, so that its owner can see it.
+func (app *application) recordLoginFailure(r *http.Request, email string) error {
+	targetID := 0
+
+	user, err := app.users.GetByEmail(email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return err
+	}
+	if user != nil {
+		targetID = user.ID
+	}
+
+	return app.recordAuditAs(r, 0, "user.login.failure", "user", targetID)
+}
+
+// The loginDisabled() helper sends someone whose account has been disabled back to the login page.
+func (app *application) loginDisabled(w http.ResponseWriter, r *http.Request) {
+	app.sessionManager.Put(r.Context(), "flash", "Your account has been disabled.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// The startLogin() helper is called once a user has proved who they are, either with their password or
+// with single sign-on. If they've turned on two-factor authentication, that isn't enough: remember who
+// they are, but *don't* log them in yet. That happens in userLoginTwoFactorPost once they've entered
+// their code. The throttle isn't reset until then either, so the code can't be guessed by entering the
+// password again between guesses.
+func (app *application) startLogin(w http.ResponseWriter, r *http.Request, id int, email string, rememberMe bool) {
+	secret, err := app.users.TOTPSecret(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if secret != "" {
+		err = app.sessionManager.RenewToken(r.Context())
+		if err != nil {
+			app.serverError(w, r, err)
+			return
 		}
+
+		app.sessionManager.Put(r.Context(), "twoFactorUserID", id)
+		app.sessionManager.Put(r.Context(), "twoFactorExpires", time.Now().Add(twoFactorTimeout).Unix())
+		app.sessionManager.Put(r.Context(), "twoFactorRememberMe", rememberMe)
+
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
 		return
 	}
 
+	app.completeLogin(w, r, id, email, rememberMe)
+}
+
+// The completeLogin() helper logs a user in, once they've passed all the checks, and redirects them.
+// If they ticked "remember me", the session lasts for longer, isn't ended by the idle timeout, and its
+// cookie is kept when the browser is closed.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, id int, email string, rememberMe bool) {
+	app.loginThrottle.succeed(email)
+
 	// Use the RenewToken() method on the current session to change the session ID. It's good practive to generate a new session ID when the authenticate state or privilege levels changes for the user (e.g. login and logout operations).
-	err = app.sessionManager.RenewToken(r.Context())
+	err := app.sessionManager.RenewToken(r.Context())
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
 	// Add the ID of the current user to the sessio, so that they are now 'logged in'.
 	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
 
-	// Redirect the user to the create snippet page.
-	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+	// RenewToken() has just set the session's deadline to the normal lifetime, so move it back for
+	// remembered sessions.
+	if rememberMe {
+		app.sessionManager.SetDeadline(r.Context(), time.Now().Add(app.config.rememberMeLifetime))
+	}
+	app.sessionManager.RememberMe(r.Context(), rememberMe)
+	app.sessionManager.Put(r.Context(), "rememberMe", rememberMe)
+
+	// Keep a record of the session, so that the user can see it on their account page and revoke it.
+	err = app.recordSession(r, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.recordAudit(r, "user.login", "user", id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// If the user was sent to the login page from a protected page, send them back there. The path is
+	// checked with safeRedirectPath() so that we can never be used to redirect someone to another site.
+	// Otherwise redirect the user to the create snippet page.
+	path := app.sessionManager.PopString(r.Context(), "redirectPathAfterLogin")
+	if !safeRedirectPath(path) {
+		path = "/snippet/create"
+	}
+	http.Redirect(w, r, path, http.StatusSeeOther)
 }
 
 func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
-	// Use the RenewToken() method of the current session to change the session
-	// ID again.
-	err := app.sessionManager.RenewToken(r.Context())
+	// Delete the record of this session, so that it no longer shows on the account page.
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err := app.userSessions.Delete(userID, app.sessionManager.GetString(r.Context(), "userSessionID"))
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.recordAudit(r, "user.logout", "user", userID)
 	if err != nil {
-		app.serverError(w, err)
-		return 
+		app.serverError(w, r, err)
+		return
 	}
 
-	// Remove the authenticatedUserIF from the session data so that the user is 'logged out'.
-	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	// Change the session ID again and remove the authenticatedUserID from the session data so that the
+	// user is 'logged out'.
+	err = app.logout(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return 
+	}
 
 	// Add a flash message to the session to confirm to the user that they've been logged out.
 	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully!")
@@ -277,6 +497,449 @@ func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// Create a new userForgotPasswordForm struct.
+type userForgotPasswordForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) userForgotPassword(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userForgotPasswordForm{}
+	app.render(w, r, http.StatusOK, "forgot.tmpl.html", data)
+}
+
+func (app *application) userForgotPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form userForgotPasswordForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "forgot.tmpl.html", data)
+		return
+	}
+
+	// Look up the user. If there's no account with this email address we still show exactly the same
+	// flash message below, so that the form can't be used to find out who has an account.
+	user, err := app.users.GetByEmail(form.Email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if user != nil {
+		// Create a single-use reset token which expires after the configured time, and email the user a
+		// link containing it.
+		token, err := app.tokens.New(user.ID, app.config.resetTokenTTL, models.ScopePasswordReset)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		err = app.mailer.Send(mailer.Message{
+			To:      user.Email,
+			Subject: "Reset your Snippetbox password",
+			Body: fmt.Sprintf("Hi %s,\n\n"+
+				"Someone (hopefully you) asked to reset the password for your Snippetbox account.\n"+
+				"To choose a new password, visit the link below within %s:\n\n"+
+				"%s/user/password/reset/%s\n\n"+
+				"If you didn't ask for this, you can safely ignore this email.\n",
+				user.Name, app.config.resetTokenTTL, app.config.baseURL, token),
+		})
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "If an account exists for that email address, we've sent it a link to reset the password.")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// Create a new userResetPasswordForm struct.
+type userResetPasswordForm struct {
+	Password            string `form:"password"`
+	ConfirmPassword     string `form:"confirm_password"`
+	Token               string `form:"-"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) userResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	form := userResetPasswordForm{Token: token}
+
+	// Check the token up front, so that someone following an old link finds out before they've typed in
+	// a new password.
+	_, err := app.tokens.UserID(models.ScopePasswordReset, token)
+	if err != nil {
+		if !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+		form.AddNonFieldError("This password reset link is invalid or has expired. Please request a new one.")
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	app.render(w, r, http.StatusOK, "reset.tmpl.html", data)
+}
+
+func (app *application) userResetPasswordPost(w http.ResponseWriter, r *http.Request) {
+	form := userResetPasswordForm{
+		Token: httprouter.ParamsFromContext(r.Context()).ByName("token"),
+	}
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
+	form.CheckField(form.ConfirmPassword == form.Password, "confirm_password", "Passwords do not match")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "reset.tmpl.html", data)
+		return
+	}
+
+	userID, err := app.tokens.UserID(models.ScopePasswordReset, form.Token)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			form.AddNonFieldError("This password reset link is invalid or has expired. Please request a new one.")
+
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "reset.tmpl.html", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.users.UpdatePassword(userID, form.Password)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.recordAuditAs(r, userID, "user.password.reset", "user", userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Delete all the user's reset tokens, so that this link (and any others they asked for) can't be used
+	// again.
+	err = app.tokens.DeleteAllForUser(models.ScopePasswordReset, userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Anyone who was logged in with the old password is logged out.
+	err = app.destroyUserSessions(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been reset. Please log in.")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) userVerify(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	// Check the token's signature and expiry against the user's current email address.
+	userID, err := app.verification.UserID(token, time.Now(), func(id int) (string, error) {
+		user, err := app.users.Get(id)
+		if err != nil {
+			return "", err
+		}
+		return user.Email, nil
+	})
+	if err != nil {
+		if errors.Is(err, errInvalidVerificationToken) || errors.Is(err, models.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", "This verification link is invalid or has expired. Log in to request a new one.")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.users.Activate(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Thanks, your email address has been verified!")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *application) userVerifyResendPost(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if user.Activated {
+		app.sessionManager.Put(r.Context(), "flash", "Your email address has already been verified.")
+		http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+		return
+	}
+
+	// Only send one email every few minutes, however many times the button is pressed.
+	if ok, wait := app.verifyResends.allow(user.ID, time.Now()); !ok {
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("We've only just sent you a verification email. Please wait %s before asking for another.", wait.Round(time.Second)))
+		http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+		return
+	}
+
+	err = app.sendVerificationEmail(user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "We've sent you a new verification email.")
+
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data, err := app.newAccountTemplateData(r, user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data.Form = accountDeleteForm{}
+	app.render(w, r, http.StatusOK, "account.tmpl.html", data)
+}
+
+// The newAccountTemplateData() helper returns the template data for the account page, including the
+// user's logged-in sessions.
+func (app *application) newAccountTemplateData(r *http.Request, user *models.User) (*templateData, error) {
+	sessions, err := app.userSessions.AllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := app.audit.ForUser(user.ID, accountAuditEvents)
+	if err != nil {
+		return nil, err
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Sessions = sessions
+	data.CurrentSessionID = app.sessionManager.GetString(r.Context(), "userSessionID")
+	data.AuditEvents = events
+	return data, nil
+}
+
+// Create a new accountPasswordUpdateForm struct.
+type accountPasswordUpdateForm struct {
+	CurrentPassword         string `form:"current_password"`
+	NewPassword             string `form:"new_password"`
+	NewPasswordConfirmation string `form:"new_password_confirmation"`
+	validator.Validator     `form:"-"`
+}
+
+func (app *application) accountPasswordUpdate(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = accountPasswordUpdateForm{}
+	app.render(w, r, http.StatusOK, "password.tmpl.html", data)
+}
+
+func (app *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http.Request) {
+	var form accountPasswordUpdateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.CurrentPassword), "current_password", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.NewPassword), "new_password", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.NewPassword, 8), "new_password", "This field must be at least 8 characters long")
+	form.CheckField(form.NewPasswordConfirmation == form.NewPassword, "new_password_confirmation", "Passwords do not match")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "password.tmpl.html", data)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	// Check the current password by authenticating with it, in exactly the same way as when logging in.
+	err = app.checkPassword(r, userID, form.CurrentPassword)
+	if err != nil {
+		var lockout *lockoutError
+		status := http.StatusUnprocessableEntity
+
+		switch {
+		case errors.As(err, &lockout):
+			form.AddFieldError("current_password", lockedOut(w, lockout.wait))
+			status = http.StatusTooManyRequests
+		case errors.Is(err, models.ErrInvalidCredentials):
+			form.AddFieldError("current_password", "Current password is incorrect")
+		default:
+			app.serverError(w, r, err)
+			return
+		}
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, status, "password.tmpl.html", data)
+		return
+	}
+
+	err = app.users.UpdatePassword(userID, form.NewPassword)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.recordAudit(r, "user.password.change", "user", userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The user's privileges haven't changed, but their credentials have, so change the session ID just
+	// like we do in userLoginPost. RenewToken() resets the session's deadline, so put it back afterwards,
+	// otherwise changing your password would change how long you stay logged in for.
+	deadline := app.sessionManager.Deadline(r.Context())
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.SetDeadline(r.Context(), deadline)
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been updated!")
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// Create a new accountDeleteForm struct. Deleting an account can't be undone, so we ask for the
+// password again first.
+type accountDeleteForm struct {
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) accountDeletePost(w http.ResponseWriter, r *http.Request) {
+	var form accountDeleteForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+
+	status := http.StatusUnprocessableEntity
+	if form.Valid() {
+		err = app.checkPassword(r, userID, form.Password)
+
+		var lockout *lockoutError
+		switch {
+		case err == nil:
+		case errors.As(err, &lockout):
+			form.AddFieldError("password", lockedOut(w, lockout.wait))
+			status = http.StatusTooManyRequests
+		case errors.Is(err, models.ErrInvalidCredentials):
+			form.AddFieldError("password", "Password is incorrect")
+		default:
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		data, err := app.newAccountTemplateData(r, user)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.Form = form
+		app.render(w, r, status, "account.tmpl.html", data)
+		return
+	}
+
+	// Delete the user's snippets first, through the snippet model, so that they are also removed from
+	// the snippet cache. Then delete the user themselves (which deletes their tokens too).
+	err = app.snippets.DeleteAllForUser(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.users.Delete(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The audit events are kept after the account has gone, so that there's a record of who deleted it
+	// and from where.
+	err = app.recordAudit(r, "user.delete", "user", userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Log the user out everywhere, including this session.
+	err = app.destroyUserSessions(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your account and all of your snippets have been deleted.")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
 func ping(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
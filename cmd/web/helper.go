@@ -2,47 +2,111 @@ package main
 
 import (
     "bytes"
+    "context"
+    "crypto/sha256"
     "fmt"
 	"errors"
     "net/http"
+    "net/url"
     "runtime/debug"
+    "strings"
     "time" 
 
+	"snippetbox.felipeacosta.net/internal/models"
+
 
 	"github.com/go-playground/form/v4"
 	"github.com/justinas/nosurf"
 )
 
-func (app *application) serverError(w http.ResponseWriter, err error) {
-    trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
+func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
+    stack := debug.Stack()
+    trace := fmt.Sprintf("%s\n%s", err.Error(), stack)
     app.errorLog.Output(2, trace)
 
-    http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+    // When (and only when) the application was started with the -debug flag, send the details of the
+    // error to the browser too.
+    if app.config.debug {
+        app.debugErrorPage(w, r, err, stack)
+        return
+    }
+
+    app.errorResponse(w, r, http.StatusInternalServerError)
+}
+
+func (app *application) clientError(w http.ResponseWriter, r *http.Request, status int) {
+    // If the request couldn't be understood because its body was cut off by the limitBody middleware,
+    // say so with a 413 Payload Too Large response instead.
+    if status == http.StatusBadRequest && app.bodyTooLarge(r) {
+        status = http.StatusRequestEntityTooLarge
+    }
+
+    app.errorResponse(w, r, status)
 }
 
-func (app *application) clientError(w http.ResponseWriter, status int) {
-    http.Error(w, http.StatusText(status), status)
+// Return true if the request body went over the limit set by the limitBody middleware.
+func (app *application) bodyTooLarge(r *http.Request) bool {
+    body, ok := r.Context().Value(limitedBodyContextKey).(*limitedBody)
+    return ok && body.tooLarge
 }
 
-func (app *application) notFound(w http.ResponseWriter) {
-    app.clientError(w, http.StatusNotFound)
+func (app *application) notFound(w http.ResponseWriter, r *http.Request) {
+    app.clientError(w, r, http.StatusNotFound)
+}
+
+// The popFlash() helper removes the flash message from the session and returns it. Error pages can be sent
+// before the session has been loaded (for example, for a panic in the middleware which runs before
+// LoadAndSave), and then there's no flash message to show.
+func (app *application) popFlash(r *http.Request) string {
+	if _, loaded := app.sessionKeys(r); !loaded {
+		return ""
+	}
+	return app.sessionManager.PopString(r.Context(), "flash")
 }
 
 func (app *application) newTemplateData(r *http.Request) *templateData {
-    return &templateData{
+    data := &templateData{
         CurrentYear: time.Now().Year(),
 		// Add the flash message to the templae data, if one exists.
-		Flash: 	app.sessionManager.PopString(r.Context(), "flash"),
+		Flash: 	app.popFlash(r),
 		IsAuthenticated: app.isAuthenticated(r),
+		// Moderators and admins see links to the pages only they can use.
+		IsModerator:     app.userRole(r).AtLeast(models.RoleModerator),
+		IsAdmin:         app.userRole(r).AtLeast(models.RoleAdmin),
 		CSRFToken: 		nosurf.Token(r), // Add the CSRF Token
     }
+
+	// Only offer single sign-on on the login page if it's been set up.
+	if app.oidc != nil {
+		data.SSOName = app.config.oidcName
+	}
+
+	return data
 }
 
-func (app *application) render(w http.ResponseWriter, status int, page string, data *templateData) {
-    ts, ok := app.templateCache[page]
+func (app *application) render(w http.ResponseWriter, r *http.Request, status int, page string, data *templateData) {
+    templateCache := app.templateCache
+
+    // In development mode, get the templates from disk (reparsing them if they've changed), and show any
+    // template errors in the browser instead of sending a bare 500 response.
+    templateError := app.serverError
+    if app.devTemplates != nil {
+        templateError = func(w http.ResponseWriter, r *http.Request, err error) {
+            app.devTemplateError(w, page, err)
+        }
+
+        var err error
+        templateCache, err = app.devTemplates.get()
+        if err != nil {
+            templateError(w, r, err)
+            return
+        }
+    }
+
+    ts, ok := templateCache[page]
     if !ok {
         err := fmt.Errorf("the template %s does not exist", page)
-        app.serverError(w, err)
+        templateError(w, r, err)
         return 
     }
 
@@ -50,7 +114,7 @@ func (app *application) render(w http.ResponseWriter, status int, page string, d
 
     err := ts.ExecuteTemplate(buf, "base", data)
     if err != nil {
-        app.serverError(w, err)
+        templateError(w, r, err)
         return 
     }
 
@@ -63,6 +127,8 @@ func (app *application) render(w http.ResponseWriter, status int, page string, d
 // Create a new decodePostForm() helper method. The second parameter here, dst, is the target destination that we want to decode the form data into.
 func (app *application) decodePostForm(r *http.Request, dst any) error {
 	// Call Parseform() on the request, in the same way that we did in our createSnippetPost handler.
+	// The body has already been capped by the limitBody middleware, so this fails (and the handler's
+	// clientError() call sends a 413 response) rather than reading a huge body into memory.
 	err := r.ParseForm()
 	if err != nil {
 		return err
@@ -96,3 +162,129 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 
 	return isAuthenticated
 }
+
+
+// Return the role of the authenticated user who made the request, or the empty role if they aren't
+// logged in.
+func (app *application) userRole(r *http.Request) models.Role {
+	role, ok := r.Context().Value(userRoleContextKey).(models.Role)
+	if !ok {
+		return ""
+	}
+
+	return role
+}
+
+
+// Return the ID of the request, which the addRequestID middleware added to its context.
+func (app *application) requestID(r *http.Request) string {
+	id, ok := r.Context().Value(requestIDContextKey).(string)
+	if !ok {
+		return ""
+	}
+
+	return id
+}
+
+
+// Return a strong ETag for the rendered view of a snippet. The page depends on the snippet itself,
+// the year in the footer and the version of the templates and static files (see pageVersion), so
+// we hash those together with the snippet ID.
+func snippetETag(s *models.Snippet, version string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\x00%s\x00%s\x00%d\x00%d\x00%d\x00%s",
+		s.ID, s.Title, s.Content, s.Created.UnixNano(), s.Expires.UnixNano(), time.Now().Year(), version)
+
+	return fmt.Sprintf(`"%d-%x"`, s.ID, h.Sum(nil)[:16])
+}
+
+// Return true if the value of an If-None-Match header matches the given ETag. The header can
+// contain a comma-separated list of ETags or "*", and If-None-Match always uses the weak
+// comparison, so any W/ prefix is ignored.
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Log the given user out of every session, by deleting the records of their sessions. The records are
+// indexed by user, so unlike the session store they can be found without looking at every session. The
+// authenticate middleware logs out any session whose record is missing on its next request, so the
+// session data left in the store can't be used any more, and it's cleaned up when it expires.
+// The ctx parameter should be the request context: if the current session belongs to the user too, it
+// gets a new token and is logged out straight away, so that the response doesn't still show them as
+// logged in.
+func (app *application) destroyUserSessions(ctx context.Context, userID int) error {
+	err := app.userSessions.DeleteAllForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	if app.sessionManager.GetInt(ctx, "authenticatedUserID") == userID {
+		err = app.sessionManager.RenewToken(ctx)
+		if err != nil {
+			return err
+		}
+		app.sessionManager.Remove(ctx, "authenticatedUserID")
+		app.sessionManager.Remove(ctx, "userSessionID")
+	}
+
+	return nil
+}
+
+// The checkPassword() helper checks a logged-in user's password, for the pages which ask for it again
+// before making a change to the account. It returns models.ErrInvalidCredentials if it's wrong. Someone
+// with a stolen session cookie could otherwise guess the password here as often as they liked, so the
+// guesses are throttled in exactly the same way as on the login page: a wrong password counts towards
+// the lockout, and while the account or the client's IP address is locked out the password isn't
+// checked at all and a *lockoutError is returned instead.
+func (app *application) checkPassword(r *http.Request, userID int, password string) error {
+	user, err := app.users.Get(userID)
+	if err != nil {
+		return err
+	}
+
+	ip := app.clientIP(r)
+	if wait := app.loginThrottle.check(user.Email, ip); wait > 0 {
+		return &lockoutError{wait: wait}
+	}
+
+	id, err := app.users.Authenticate(user.Email, password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			app.loginFailed(user.Email, ip)
+		}
+		return err
+	}
+	if id != userID {
+		return models.ErrInvalidCredentials
+	}
+
+	app.loginThrottle.succeed(user.Email)
+	return nil
+}
+
+// The safeRedirectPath() helper reports whether target is a path on this site which is safe to redirect
+// to. Anything with a scheme or host is rejected, as are paths like "//evil.example" and "/\evil.example"
+// which browsers treat as links to another host.
+func safeRedirectPath(target string) bool {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.ContainsAny(target, "\\\r\n\t") {
+		return false
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+
+	return u.Scheme == "" && u.Host == "" && u.User == nil
+}
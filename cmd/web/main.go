@@ -1,19 +1,29 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"database/sql"
+	"errors"
 	"flag"
 	"html/template"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	// Import the models package. You need to prefix this with
 	// Whatever module path you set up. Example:
 	// "{your-module_path}/internal/models". You can find it in the go.mod file.
+	"snippetbox.felipeacosta.net/internal/mailer"
 	"snippetbox.felipeacosta.net/internal/models"
+	"snippetbox.felipeacosta.net/internal/oidc"
+	"snippetbox.felipeacosta.net/internal/secretscan"
+	"snippetbox.felipeacosta.net/ui"
 
 	"github.com/alexedwards/scs/mysqlstore"
 	"github.com/alexedwards/scs/v2"
@@ -31,30 +41,141 @@ import (
 // Add a formDecoder field to hold a pointer to a form.Decoder instance.
 // Add a new sessionManager field to the application struct
 // Add a new users field to the application struct.
+// Add a config field holding the settings which the handlers and middleware need at runtime.
 type application struct {
+	config         config
 	errorLog       *log.Logger
 	infoLog        *log.Logger
+	auditLog       *log.Logger
 	snippets       models.SnippetModelInterface
 	users          models.UserModelInterface
+	tokens         models.TokenModelInterface
+	recoveryCodes  models.RecoveryCodeModelInterface
+	userSessions   models.UserSessionModelInterface
+	audit          models.AuditModelInterface
+	reports        models.ReportModelInterface
+	secretScanner  *secretscan.Scanner
+	verification   verificationTokens
+	verifyResends  *resendLimiter
+	loginThrottle  *loginThrottle
+	rateLimiters   map[string]*rateLimiter
+	oidc           *oidc.Provider
+	mailer         mailer.Mailer
 	templateCache  map[string]*template.Template
+	devTemplates   *devTemplates
 	formDecoder    *form.Decoder
 	sessionManager *scs.SessionManager
+	staticAssets   *staticAssets
+	// pageVersion identifies this build's templates and static files, for
+	// the ETags of cached pages.
+	pageVersion string
+}
+
+// Define a config struct to hold the command-line settings which are used while serving
+// requests (as opposed to the ones, like the DSN, which are only needed at startup).
+type config struct {
+	// Responses smaller than compressMinSize bytes are sent uncompressed, because
+	// the compression overhead isn't worth it for them.
+	compressMinSize int
+	// In development mode the templates are parsed from uiDir on disk and
+	// reparsed whenever they change. Production uses the embedded templates.
+	dev   bool
+	uiDir string
+	// In debug mode, server errors and panics are shown in the browser as a
+	// detailed page with the stack trace and request details.
+	debug bool
+	// baseURL is used to build the absolute links which we send out by email,
+	// like password reset links.
+	baseURL string
+	// How long a password reset link stays valid for.
+	resetTokenTTL time.Duration
+	// How long an email verification link stays valid for.
+	verifyTokenTTL time.Duration
+	// Requests from these addresses are from our own reverse proxies, so the
+	// client's real IP address is taken from the X-Forwarded-For header.
+	trustedProxies []*net.IPNet
+	// The name of the single sign-on provider, shown on the login page.
+	oidcName string
+	// Logged-in sessions are ended after sessionIdleTimeout without any
+	// requests, unless the user ticked "remember me", in which case the
+	// session lasts for rememberMeLifetime instead of the normal lifetime.
+	sessionIdleTimeout time.Duration
+	rememberMeLifetime time.Duration
+	// The maximum length of a snippet's content, in characters.
+	maxSnippetLength int
+	// A snippet is hidden automatically once it has this many open reports
+	// (0 to leave it to the moderators).
+	reportHideThreshold int
 }
 
 func main() {
+	var cfg config
+
 	addr := flag.String("addr", ":4000", "HTTP network address")
 	// Define a new comand-line flag for the MySQL DSN string. When git pushing it change password to web:pass@/
 	dsn := flag.String("dsn", "username:password@/snippetbox?parseTime=true", "MySQL data source name")
+	// Define flags for tuning the database connection pool.
+	dbMaxOpenConns := flag.Int("db-max-open-conns", 25, "MySQL max open connections")
+	dbMaxIdleConns := flag.Int("db-max-idle-conns", 25, "MySQL max idle connections")
+	dbConnMaxLifetime := flag.Duration("db-conn-max-lifetime", 5*time.Minute, "MySQL max connection lifetime")
+	// Define flags for the in-process cache of hot snippet and user reads.
+	cacheSize := flag.Int("cache-size", 1000, "Maximum number of entries in each in-process cache")
+	cacheTTL := flag.Duration("cache-ttl", 30*time.Second, "How long entries stay in the in-process caches")
+	flag.IntVar(&cfg.compressMinSize, "compress-min-size", 1024, "Minimum response size in bytes before compressing")
+	// Define flags for development mode. Never use -dev in production.
+	flag.BoolVar(&cfg.dev, "dev", false, "Development mode: reload templates from disk and show template errors in the browser")
+	flag.StringVar(&cfg.uiDir, "ui-dir", "./ui", "Path to the ui folder on disk (only used with -dev)")
+	flag.BoolVar(&cfg.debug, "debug", false, "Debug mode: show stack traces and request details on error pages (never use in production)")
+	flag.StringVar(&cfg.baseURL, "base-url", "https://localhost:4000", "Base URL for links in emails")
+	flag.DurationVar(&cfg.resetTokenTTL, "reset-token-ttl", time.Hour, "How long password reset links are valid for")
+	flag.DurationVar(&cfg.verifyTokenTTL, "verify-token-ttl", 24*time.Hour, "How long email verification links are valid for")
+	verifyResendInterval := flag.Duration("verify-resend-interval", 5*time.Minute, "Minimum time between verification emails to the same user")
+	// Define flags for the brute-force protection on the login form.
+	loginFreeAttempts := flag.Int("login-free-attempts", 5, "Failed logins allowed per account before lockouts start")
+	loginIPFreeAttempts := flag.Int("login-ip-free-attempts", 20, "Failed logins allowed per client IP before lockouts start")
+	loginBackoff := flag.Duration("login-backoff", time.Second, "Length of the first login lockout (doubled for each further failure)")
+	loginMaxLockout := flag.Duration("login-max-lockout", 15*time.Minute, "Longest login lockout")
+	// Define flags for the per-IP rate limits.
+	rateLimitEnabled := flag.Bool("rate-limit", true, "Enable the per-IP rate limits")
+	rateLimits := flag.String("rate-limits", defaultRateLimits, "Per-IP rate limits for each route group (global, dynamic and protected), as group=requests-per-second:burst. Each request counts against one group only")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma-separated IPs or CIDR ranges of reverse proxies whose X-Forwarded-For header is trusted")
+	// Define flags for how long logged-in sessions last.
+	sessionLifetime := flag.Duration("session-lifetime", 12*time.Hour, "Absolute lifetime of a session")
+	flag.DurationVar(&cfg.sessionIdleTimeout, "session-idle-timeout", time.Hour, "Log users out after this long without a request, unless they ticked \"remember me\" (0 to disable)")
+	flag.DurationVar(&cfg.rememberMeLifetime, "remember-me-lifetime", 30*24*time.Hour, "Absolute lifetime of a session when the user ticked \"remember me\"")
+	// Define flags for single sign-on with an OpenID Connect provider. It's switched off unless an issuer is given.
+	oidcIssuer := flag.String("oidc-issuer", "", "OpenID Connect issuer URL for single sign-on (leave empty to disable)")
+	oidcClientID := flag.String("oidc-client-id", "", "OpenID Connect client ID")
+	oidcClientSecret := flag.String("oidc-client-secret", "", "OpenID Connect client secret")
+	flag.StringVar(&cfg.oidcName, "oidc-name", "single sign-on", "Name of the OpenID Connect provider shown on the login page")
+	flag.IntVar(&cfg.maxSnippetLength, "max-snippet-length", 10000, "Maximum length of a snippet's content, in characters")
+	flag.IntVar(&cfg.reportHideThreshold, "report-hide-threshold", 3, "Hide a snippet automatically once it has this many open reports (0 to disable)")
+	// Define flags for the scan for credentials in new snippets.
+	secretScan := flag.String("secret-scan", "all", "Built-in secret scanning rules to use: all, none, or a comma-separated list of rule names")
+	secretScanRules := flag.String("secret-scan-rules", "", "File of extra secret scanning rules, one \"name regexp\" per line")
+	// Define a flag for bootstrapping the first admin. There's no other way to make someone an admin
+	// until there is one.
+	adminEmail := flag.String("admin-email", "", "Email address of a user to make an admin at startup")
+	// Define a flag for the key used to sign email verification links.
+	secretKey := flag.String("secret-key", "", "Secret key for signing email verification links (random if empty)")
+	// Define flags for sending email. If no SMTP host is given, emails are written to the info log instead.
+	smtpHost := flag.String("smtp-host", "", "SMTP host (leave empty to log emails instead of sending them)")
+	smtpPort := flag.Int("smtp-port", 587, "SMTP port")
+	smtpUsername := flag.String("smtp-username", "", "SMTP username")
+	smtpPassword := flag.String("smtp-password", "", "SMTP password")
+	smtpSender := flag.String("smtp-sender", "Snippetbox <no-reply@snippetbox.felipeacosta.net>", "SMTP sender")
 
 	flag.Parse()
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	// Security events, like account lockouts, are written to their own logger so that they're easy to pick out.
+	auditLog := log.New(os.Stdout, "AUDIT\t", log.Ldate|log.Ltime)
 
 	// To keep the main() function tidy I've put the code for creating a connection
 	// pool into the seperate openDB() function below. We pass openDB() the DSN
-	// from the command-line flag.
-	db, err := openDB(*dsn)
+	// and the pool settings from the command-line flags.
+	db, err := openDB(*dsn, *dbMaxOpenConns, *dbMaxIdleConns, *dbConnMaxLifetime)
 	if err != nil {
 		errorLog.Fatal(err)
 	}
@@ -63,6 +184,22 @@ func main() {
 	// before the main() function exits.
 	defer db.Close()
 
+	// Prepare the statements which are run on (almost) every request once, up
+	// front. Deferred calls run in reverse order, so the statements are closed
+	// before the connection pool is.
+	stmts, err := models.NewStmtCache(db)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	defer stmts.Close()
+
+	// Wrap the real models in the caching decorators. The handlers only ever see
+	// the model interfaces, so they don't need to know that the reads might be
+	// coming from memory.
+	snippets := models.NewCachedSnippetModel(&models.SnippetModel{DB: db, Stmts: stmts}, *cacheSize, *cacheTTL)
+	users := models.NewCachedUserModel(&models.UserModel{DB: db, Stmts: stmts}, *cacheSize, *cacheTTL)
+	userSessions := models.NewCachedUserSessionModel(&models.UserSessionModel{DB: db}, *cacheSize, *cacheTTL)
+
 	// Initialize a new tamplet cache...
 	// And add it to teh application dependencies below
 	templateCache, err := newTemplateCache()
@@ -70,14 +207,116 @@ func main() {
 		errorLog.Fatal(err)
 	}
 
+	// Debug mode leaks the application's internals to anyone who can trigger
+	// an error, so make it very obvious in the logs when it's switched on.
+	if cfg.debug {
+		errorLog.Print("WARNING: debug mode is enabled; detailed error pages will be shown to clients")
+	}
+
+	// In development mode, read the templates from disk instead, so that
+	// changes show up without a rebuild.
+	var devTmpl *devTemplates
+	if cfg.dev {
+		devTmpl = newDevTemplates(os.DirFS(cfg.uiDir))
+		infoLog.Printf("Development mode: reloading templates from %s", cfg.uiDir)
+	}
+
+	// Build the manifest of fingerprinted static files, precompressing them so
+	// that they don't have to be compressed again on every request.
+	staticAssets, err := loadStaticAssets()
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	version, err := pageVersion(ui.Files, staticAssets)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	// Pick the mailer. Without an SMTP server we just log the emails, which is
+	// handy in development.
+	var m mailer.Mailer = &mailer.Log{Logger: infoLog}
+	if *smtpHost != "" {
+		m = &mailer.SMTP{
+			Host:     *smtpHost,
+			Port:     *smtpPort,
+			Username: *smtpUsername,
+			Password: *smtpPassword,
+			Sender:   *smtpSender,
+		}
+	}
+
+	cfg.trustedProxies, err = parseTrustedProxies(*trustedProxies)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	// Create a token bucket rate limiter for each route group.
+	var rateLimiters map[string]*rateLimiter
+	if *rateLimitEnabled {
+		limits, err := parseRateLimits(*rateLimits)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+
+		rateLimiters = make(map[string]*rateLimiter)
+		for group, limit := range limits {
+			rateLimiters[group] = newRateLimiter(limit)
+		}
+	}
+
+	// Snippets are stored in a TEXT column, which holds 65,535 bytes. A character can take up to 4
+	// bytes, so that's at most 16,383 characters.
+	if cfg.maxSnippetLength < 1 || cfg.maxSnippetLength > 16383 {
+		errorLog.Fatal("-max-snippet-length must be between 1 and 16383")
+	}
+
+	// Build the scanner which looks for credentials in new snippets.
+	secretScanner, err := newSecretScanner(*secretScan, *secretScanRules)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	// Fetch the identity provider's configuration, if single sign-on is
+	// switched on. The provider redirects users back to our callback URL,
+	// which must be registered with it.
+	var oidcProvider *oidc.Provider
+	if *oidcIssuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		oidcProvider, err = oidc.Discover(ctx, &http.Client{Timeout: 10 * time.Second}, *oidcIssuer)
+		cancel()
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+
+		oidcProvider.ClientID = *oidcClientID
+		oidcProvider.ClientSecret = *oidcClientSecret
+		oidcProvider.RedirectURL = cfg.baseURL + "/user/login/oidc/callback"
+	}
+
+	// Without a secret key from the command line, generate a random one. That
+	// works, but any verification links which have already been sent out stop
+	// working when the application restarts.
+	verificationKey := []byte(*secretKey)
+	if len(verificationKey) == 0 {
+		verificationKey = make([]byte, 32)
+		if _, err := rand.Read(verificationKey); err != nil {
+			errorLog.Fatal(err)
+		}
+		errorLog.Print("WARNING: no -secret-key given; using a random key, so verification links won't survive a restart")
+	}
+
 	// Initialize a decoder instance...
 	formDecoder := form.NewDecoder()
 
 	// Use the scs.New() function to initialize a new session manager. Then we configure it to use our MySQL database
 	// as the session store, and set a lifetime of 12 hours (so that sessions atuo exipre 12 hours after first being created.
+	// The lifetime now comes from the -session-lifetime flag.
 	sessionManager := scs.New()
 	sessionManager.Store = mysqlstore.New(db)
-	sessionManager.Lifetime = 12 * time.Hour
+	sessionManager.Lifetime = *sessionLifetime
+	// Only keep the session cookie after the browser is closed if the user asked us to remember them.
+	sessionManager.Cookie.Persist = false
 	// Make sure that the Secure attribute is set on our session cookies. Setting this means that the cookie will only be sent by a user's web browser when a HTTPS connection is being used (and won't be sent over an unsecure HTTP connection).
 	sessionManager.Cookie.Secure = true
 
@@ -88,13 +327,38 @@ func main() {
 	// And add the session manager to our application dependencies.
 	// Initialize a models.UserModel instance and add it to the application dependencies.
 	app := &application{
+		config:         cfg,
 		errorLog:       errorLog,
 		infoLog:        infoLog,
-		snippets:       &models.SnippetModel{DB: db},
-		users:          &models.UserModel{DB: db},
+		auditLog:       auditLog,
+		snippets:       snippets,
+		users:          users,
+		tokens:         &models.TokenModel{DB: db},
+		recoveryCodes:  &models.RecoveryCodeModel{DB: db},
+		userSessions:   userSessions,
+		audit:          &models.AuditModel{DB: db},
+		reports:        &models.ReportModel{DB: db},
+		secretScanner:  secretScanner,
+		verification:   verificationTokens{key: verificationKey},
+		verifyResends:  newResendLimiter(*verifyResendInterval),
+		loginThrottle:  newLoginThrottle(*loginFreeAttempts, *loginIPFreeAttempts, *loginBackoff, *loginMaxLockout),
+		rateLimiters:   rateLimiters,
+		oidc:           oidcProvider,
+		mailer:         m,
 		templateCache:  templateCache,
+		devTemplates:   devTmpl,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
+		staticAssets:   staticAssets,
+		pageVersion:    version,
+	}
+
+	// Promote the user given by -admin-email to admin, if there is one.
+	if *adminEmail != "" {
+		err = app.promoteAdmin(*adminEmail)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
 	}
 
 	// Initialize a tls.Config struct to hold the non-default TLS settings we want the server to use. In this case the only thing that we're changing is the curve preferences value, so that only elliptic curves with assembly implementations are used.
@@ -115,20 +379,63 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
+	// Start a background goroutine which waits for a SIGINT or SIGTERM signal and
+	// then gracefully shuts the server down. Returning normally from main() (rather
+	// than calling errorLog.Fatal) means that the deferred calls above get to close
+	// the prepared statements and the connection pool.
+	shutdownErr := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		infoLog.Printf("Shutting down server (%s)", s)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		shutdownErr <- srv.Shutdown(ctx)
+	}()
+
 	infoLog.Printf("Starting serve on %s", *addr)
 	// Because the err variable is now already declared in the code above, we need
 	// to use the assignment operator = here, instead of the := `declare and assign` operator.
 	// Use the ListenAndServeTLS() method to start the HTTPS server. We pass in the paths to the TLS certificate and corresponding private key as the two parameters.
+	// Once Shutdown() has been called it returns http.ErrServerClosed straight away, so anything else is a real error.
 	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	errorLog.Fatal(err)
+	if !errors.Is(err, http.ErrServerClosed) {
+		errorLog.Fatal(err)
+	}
+
+	// Wait for Shutdown() to finish draining the in-flight requests.
+	if err = <-shutdownErr; err != nil {
+		errorLog.Print(err)
+		return
+	}
+
+	// Log how effective the caches were over the lifetime of the process.
+	getStats, latestStats := snippets.Stats()
+	existsStats, roleStats := users.Stats()
+	infoLog.Printf("Cache stats: snippets %+v, latest %+v, users %+v, roles %+v, sessions %+v", getStats, latestStats, existsStats, roleStats, userSessions.Stats())
+
+	infoLog.Print("Server stopped")
 }
 
-// The openDB() function wraps sql.Open() and returns a sql.DB connection pool for a given DSN.
-func openDB(dsn string) (*sql.DB, error) {
+// The openDB() function wraps sql.Open() and returns a sql.DB connection pool for a given DSN,
+// configured with the given pool limits.
+func openDB(dsn string, maxOpenConns, maxIdleConns int, connMaxLifetime time.Duration) (*sql.DB, error) {
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		return nil, err
 	}
+
+	// Set the maximum number of open (in-use + idle) connections, the maximum number
+	// of idle connections kept in the pool, and how long a connection can be reused
+	// for. A value of zero means "no limit" for all three.
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
+	db.SetConnMaxLifetime(connMaxLifetime)
+
 	if err = db.Ping(); err != nil {
 		return nil, err
 	}
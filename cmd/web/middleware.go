@@ -2,8 +2,16 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
     "fmt"
+	"io"
+    "net"
     "net/http"
+    "regexp"
+
+	"snippetbox.felipeacosta.net/internal/models"
 
 	"github.com/justinas/nosurf"
 )
@@ -32,12 +40,45 @@ func secureHeaders(next http.Handler) http.Handler {
 // logRequest <-> secureHeader <-> servemux <-> application handler
 func (app *application) logRequest(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        app.infoLog.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
+        app.infoLog.Printf("%s - %s %s %s request_id=%s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI(), app.requestID(r))
 
         next.ServeHTTP(w, r)
     })
 }
 
+// The addRequestID() middleware gives every request an ID, which is sent back in the X-Request-Id header
+// and included in the log lines and audit events for the request, so that they can be matched up. If the
+// request came through one of our trusted proxies and it has already given the request an ID, we use that
+// one instead, so that the proxy's logs match too.
+func (app *application) addRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := ""
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			if ip := net.ParseIP(host); ip != nil && app.isTrustedProxy(ip) {
+				id = r.Header.Get("X-Request-Id")
+			}
+		}
+
+		if !requestIDRX.MatchString(id) {
+			b := make([]byte, 8)
+			if _, err := rand.Read(b); err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-Id", id)
+
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestIDRX matches the request IDs which we accept from a proxy. Anything else could be used to forge
+// log lines, so it's replaced with one of our own.
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
 // Create some middleware which recovers the panic and calls our app.serverError() helper method. 
 // to do this, we can leverage the fact that deferred functions are always called when the stack is being unwoud following a panic.
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -52,7 +93,7 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
                 w.Header().Set("Connection", "close")
                 // Call the app.serverError helper method to return a 500
                 // Internal server response.
-                app.serverError(w, fmt.Errorf("%s", err))
+                app.serverError(w, r, fmt.Errorf("%s", err))
             }
         }() 
         next.ServeHTTP(w, r)
@@ -63,6 +104,12 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// If the user is not authenticated, redirect them to the login page and return from the middleware chain so that no subsequent handlers in the chain are executed.
 		if !app.isAuthenticated(r) {
+			// Remember which page they were trying to get to, so that userLoginPost can send them back there
+			// once they've logged in. We only do this for GET requests, because a form submission can't be
+			// replayed with a redirect.
+			if r.Method == http.MethodGet {
+				app.sessionManager.Put(r.Context(), "redirectPathAfterLogin", r.URL.RequestURI())
+			}
 			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 			return
 		}
@@ -76,15 +123,108 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 }
 
 
+// The requireRole() middleware only lets users with at least the given role through. It goes after
+// requireAuthentication in the chain, by appending it to the 'protected' chain, so anyone who gets
+// this far is logged in; they just aren't allowed to see the page.
+func (app *application) requireRole(role models.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.userRole(r).AtLeast(role) {
+				app.clientError(w, r, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+
+// The limitBody() middleware caps the size of the request body at n bytes, so that a huge POST can't
+// use up all our memory. Requests which say up front that their body is too big get a 413 Payload Too
+// Large response straight away. Otherwise the body is cut off when it reaches the limit, and reading
+// the form fails. The first limitBody in a chain wins, so a route which needs a different limit can
+// put its own in front of the chain's.
+func (app *application) limitBody(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := r.Context().Value(limitedBodyContextKey).(*limitedBody); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if r.ContentLength > n {
+				w.Header().Set("Connection", "close")
+				app.clientError(w, r, http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			body := &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, n)}
+			r.Body = body
+
+			ctx := context.WithValue(r.Context(), limitedBodyContextKey, body)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// maxFormBytes is the limit on the size of the body of most of our forms, which only have a few short
+// fields. The snippet form gets a bigger limit, from snippetBodyLimit().
+const maxFormBytes = 32 << 10
+
+// snippetBodyLimit returns the limit on the size of the body of the snippet form. In the worst case
+// each character of the content is 4 bytes of UTF-8, and each of those is URL-encoded as 3 bytes
+// ("%E2"), so we allow for that plus the other fields.
+func (app *application) snippetBodyLimit() int64 {
+	return int64(app.config.maxSnippetLength)*4*3 + maxFormBytes
+}
+
+// A limitedBody remembers whether the request body went over the limit, so that we can send a 413
+// response instead of a 400 when the form can't be read because of it.
+type limitedBody struct {
+	io.ReadCloser
+	tooLarge bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		b.tooLarge = true
+	}
+
+	return n, err
+}
+
+
 // Create a NoSurf middleware function which uses a customized CSRF cookie with the Secure,
 // Path and HttpOnly attributes set. 
-func noSurf(next http.Handler) http.Handler {
+// Requests which fail the CSRF check get our normal 400 Bad Request error page.
+func (app *application) noSurf(next http.Handler) http.Handler {
+	return app.newCSRFHandler(next)
+}
+
+// The csrfTokenOnly() middleware makes the CSRF token available to the templates, like noSurf, but
+// doesn't check it. It's for the router's error pages: a POST to a missing page should get a 404 and
+// not a CSRF failure, but the page still needs the token for the logout form in the navigation.
+func (app *application) csrfTokenOnly(next http.Handler) http.Handler {
+	csrfHandler := app.newCSRFHandler(next)
+	csrfHandler.ExemptFunc(func(r *http.Request) bool { return true })
+
+	return csrfHandler
+}
+
+func (app *application) newCSRFHandler(next http.Handler) *nosurf.CSRFHandler {
 	csrfHandler := nosurf.New(next)
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
 		Path: "/",
 		Secure: true,
 	})
+	csrfHandler.SetFailureHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.clientError(w, r, http.StatusBadRequest)
+	}))
 
 	return csrfHandler
 }
@@ -102,10 +242,12 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		// Otherwise, we check to see if a user with that ID exists in our
-		// database.
-		exists, err := app.users.Exists(id)
-		if err != nil {
-			app.serverError(w, err)
+		// database. Looking up their role does that too, because there's no
+		// role for a user who doesn't exist.
+		role, err := app.users.Role(id)
+		exists := err == nil
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
 			return
 		}
 
@@ -113,8 +255,29 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// an authenticated user who exists in our database. We create a new copy of the 
 		// request (with an isAuthenticatedContextKey value of true in the request context)
 		// and assign it to r.
+		// The session must also still be on record. If the user has revoked it (from another device, say)
+		// log it out now, so that it can't be used again.
 		if exists {
+			current, err := app.checkSession(r, id)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			if !current {
+				err = app.logout(r)
+				if err != nil {
+					app.serverError(w, r, err)
+					return
+				}
+				app.sessionManager.Put(r.Context(), "flash", "Your session has ended. Please log in again.")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Store their role in the request context too, for requireRole and the templates.
 			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+			ctx = context.WithValue(ctx, userRoleContextKey, role)
 			r = r.WithContext(ctx)
 		}
 
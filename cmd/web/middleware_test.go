@@ -2,12 +2,22 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"snippetbox.felipeacosta.net/internal/assert"
+	"snippetbox.felipeacosta.net/internal/models"
+
+	"github.com/andybalholm/brotli"
+	"github.com/justinas/alice"
 )
 
 func TestSecureHeader(t *testing.T) {
@@ -67,3 +77,666 @@ func TestSecureHeader(t *testing.T) {
 	assert.Equal(t, string(body), "OK")
 }
 
+
+func TestCompress(t *testing.T) {
+	app := &application{
+		config:   config{compressMinSize: 1024},
+		errorLog: log.New(io.Discard, "", 0),
+	}
+
+	longText := strings.Repeat("An old silent pond... ", 100)
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           string
+		wantEncoding   string
+	}{
+		{
+			name:           "Gzip",
+			acceptEncoding: "gzip",
+			contentType:    "text/html; charset=utf-8",
+			body:           longText,
+			wantEncoding:   "gzip",
+		},
+		{
+			name:           "Brotli preferred",
+			acceptEncoding: "gzip, deflate, br",
+			contentType:    "text/html; charset=utf-8",
+			body:           longText,
+			wantEncoding:   "br",
+		},
+		{
+			name:           "Brotli refused",
+			acceptEncoding: "br;q=0, gzip",
+			contentType:    "text/css",
+			body:           longText,
+			wantEncoding:   "gzip",
+		},
+		{
+			name:           "No Accept-Encoding",
+			acceptEncoding: "",
+			contentType:    "text/html; charset=utf-8",
+			body:           longText,
+			wantEncoding:   "",
+		},
+		{
+			name:           "Below minimum size",
+			acceptEncoding: "gzip",
+			contentType:    "text/html; charset=utf-8",
+			body:           "OK",
+			wantEncoding:   "",
+		},
+		{
+			name:           "Already compressed type",
+			acceptEncoding: "gzip",
+			contentType:    "image/png",
+			body:           longText,
+			wantEncoding:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write([]byte(tt.body))
+			})
+
+			app.compress(next).ServeHTTP(rr, r)
+
+			rs := rr.Result()
+			defer rs.Body.Close()
+
+			assert.Equal(t, rs.StatusCode, http.StatusOK)
+			assert.Equal(t, rs.Header.Get("Content-Encoding"), tt.wantEncoding)
+			assert.Equal(t, rs.Header.Get("Vary"), "Accept-Encoding")
+
+			// Decompress the body (if necessary) and check that it survived
+			// the round trip.
+			var body io.Reader = rs.Body
+			switch tt.wantEncoding {
+			case "gzip":
+				body, err = gzip.NewReader(rs.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+			case "br":
+				body = brotli.NewReader(rs.Body)
+			}
+
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, string(got), tt.body)
+		})
+	}
+}
+
+
+func TestCompressNotModified(t *testing.T) {
+	app := &application{
+		config:   config{compressMinSize: 1024},
+		errorLog: log.New(io.Discard, "", 0),
+	}
+
+	longText := strings.Repeat("An old silent pond... ", 100)
+
+	// Each handler sends a 200 response, or a 304 if the request has an If-None-Match header. The 304
+	// must have the same ETag as the 200, whether or not the 200 was compressed.
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		// notModifiedType is the Content-Type of the 304 response.
+		notModifiedType string
+		wantEncoding    string
+		wantETag        string
+	}{
+		{
+			name:            "Compressed",
+			contentType:     "text/html; charset=utf-8",
+			body:            longText,
+			notModifiedType: "text/html; charset=utf-8",
+			wantEncoding:    "gzip",
+			wantETag:        `W/"1"`,
+		},
+		{
+			// Like http.ServeContent(), which removes the Content-Type from 304 responses.
+			name:         "Below minimum size",
+			contentType:  "text/css; charset=utf-8",
+			body:         "body {}",
+			wantEncoding: "",
+			wantETag:     `"1"`,
+		},
+		{
+			name:            "Already compressed type",
+			contentType:     "image/png",
+			body:            longText,
+			notModifiedType: "image/png",
+			wantEncoding:    "",
+			wantETag:        `"1"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"1"`)
+				if r.Header.Get("If-None-Match") != "" {
+					if tt.notModifiedType != "" {
+						w.Header().Set("Content-Type", tt.notModifiedType)
+					}
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write([]byte(tt.body))
+			})
+
+			for _, ifNoneMatch := range []string{"", tt.wantETag} {
+				rr := httptest.NewRecorder()
+
+				r, err := http.NewRequest(http.MethodGet, "/", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r.Header.Set("Accept-Encoding", "gzip")
+				if ifNoneMatch != "" {
+					r.Header.Set("If-None-Match", ifNoneMatch)
+				}
+
+				app.compress(next).ServeHTTP(rr, r)
+
+				if ifNoneMatch == "" {
+					assert.Equal(t, rr.Code, http.StatusOK)
+					assert.Equal(t, rr.Header().Get("Content-Encoding"), tt.wantEncoding)
+				} else {
+					assert.Equal(t, rr.Code, http.StatusNotModified)
+				}
+				assert.Equal(t, rr.Header().Get("ETag"), tt.wantETag)
+			}
+		})
+	}
+}
+
+func TestRecoverPanic(t *testing.T) {
+	tests := []struct {
+		name          string
+		debug         bool
+		wantBody      string
+		wantNotInBody string
+	}{
+		{
+			name:          "Production",
+			debug:         false,
+			wantBody:      "Internal Server Error",
+			wantNotInBody: "oops! something went wrong",
+		},
+		{
+			name:     "Debug",
+			debug:    true,
+			wantBody: "oops! something went wrong",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.debug = tt.debug
+
+			rr := httptest.NewRecorder()
+
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				panic("oops! something went wrong")
+			})
+
+			app.recoverPanic(next).ServeHTTP(rr, r)
+
+			rs := rr.Result()
+			defer rs.Body.Close()
+
+			body, err := io.ReadAll(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, rs.StatusCode, http.StatusInternalServerError)
+			assert.StringContains(t, string(body), tt.wantBody)
+
+			if tt.wantNotInBody != "" && strings.Contains(string(body), tt.wantNotInBody) {
+				t.Errorf("body contains %q", tt.wantNotInBody)
+			}
+
+			if tt.debug {
+				assert.StringContains(t, string(body), "Stack trace")
+				assert.StringContains(t, string(body), "The session hadn't been loaded")
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+
+	now := time.Date(2024, 3, 17, 10, 15, 0, 0, time.UTC)
+	limiter := newRateLimiter(rateLimit{rate: 1, burst: 2})
+	limiter.now = func() time.Time { return now }
+	app.rateLimiters = map[string]*rateLimiter{rateGroupGlobal: limiter}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	handler := app.rateLimit(rateGroupGlobal)(next)
+
+	send := func(remoteAddr, path string) *http.Response {
+		rr := httptest.NewRecorder()
+		r, err := http.NewRequest(http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.RemoteAddr = remoteAddr
+		handler.ServeHTTP(rr, r)
+		return rr.Result()
+	}
+
+	// The first two requests use up the burst, and the third is refused.
+	assert.Equal(t, send("192.0.2.1:1234", "/").StatusCode, http.StatusOK)
+	assert.Equal(t, send("192.0.2.1:1234", "/").StatusCode, http.StatusOK)
+
+	rs := send("192.0.2.1:5678", "/")
+	assert.Equal(t, rs.StatusCode, http.StatusTooManyRequests)
+	assert.Equal(t, rs.Header.Get("Retry-After"), "1")
+
+	// Other clients have their own buckets.
+	assert.Equal(t, send("198.51.100.1:1234", "/").StatusCode, http.StatusOK)
+
+	// After a second there's a token for one more request.
+	now = now.Add(time.Second)
+	assert.Equal(t, send("192.0.2.1:1234", "/").StatusCode, http.StatusOK)
+	assert.Equal(t, send("192.0.2.1:1234", "/").StatusCode, http.StatusTooManyRequests)
+
+	// Idle buckets are eventually removed.
+	now = now.Add(time.Hour)
+	send("203.0.113.1:1234", "/")
+	assert.Equal(t, len(limiter.buckets), 1)
+}
+
+func TestRateLimitGroups(t *testing.T) {
+	app := newTestApplication(t)
+
+	// Give each group a single token, so that the second request which counts against a group is refused.
+	app.rateLimiters = map[string]*rateLimiter{}
+	for _, group := range rateGroups {
+		app.rateLimiters[group] = newRateLimiter(rateLimit{rate: 0.001, burst: 1})
+	}
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Each request only counts against its own group, so using up one group's token doesn't affect the
+	// others.
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+	}{
+		{name: "Dynamic", urlPath: "/", wantCode: http.StatusOK},
+		{name: "Dynamic again", urlPath: "/user/login", wantCode: http.StatusTooManyRequests},
+		{name: "Protected", urlPath: "/snippet/create", wantCode: http.StatusSeeOther},
+		{name: "Protected again", urlPath: "/account/view", wantCode: http.StatusTooManyRequests},
+		{name: "Global", urlPath: "/ping", wantCode: http.StatusOK},
+		{name: "Error page", urlPath: "/missing", wantCode: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	app := newTestApplication(t)
+
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.config.trustedProxies = proxies
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		xForwardedFor []string
+		want          string
+	}{
+		{
+			name:       "Direct connection",
+			remoteAddr: "198.51.100.7:1234",
+			want:       "198.51.100.7",
+		},
+		{
+			name:          "Untrusted peer with forged header",
+			remoteAddr:    "198.51.100.7:1234",
+			xForwardedFor: []string{"203.0.113.9"},
+			want:          "198.51.100.7",
+		},
+		{
+			name:          "Trusted proxy",
+			remoteAddr:    "10.1.2.3:1234",
+			xForwardedFor: []string{"203.0.113.9"},
+			want:          "203.0.113.9",
+		},
+		{
+			name:          "Chain of trusted proxies",
+			remoteAddr:    "10.1.2.3:1234",
+			xForwardedFor: []string{"203.0.113.9, 192.0.2.1", "10.9.9.9"},
+			want:          "203.0.113.9",
+		},
+		{
+			name:          "Client-supplied entries are ignored",
+			remoteAddr:    "10.1.2.3:1234",
+			xForwardedFor: []string{"1.1.1.1, 203.0.113.9"},
+			want:          "203.0.113.9",
+		},
+		{
+			name:          "Malformed entry",
+			remoteAddr:    "10.1.2.3:1234",
+			xForwardedFor: []string{"203.0.113.9, not-an-ip"},
+			want:          "10.1.2.3",
+		},
+		{
+			name:       "Trusted proxy without header",
+			remoteAddr: "10.1.2.3:1234",
+			want:       "10.1.2.3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.xForwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			assert.Equal(t, app.clientIP(r), tt.want)
+		})
+	}
+}
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := parseRateLimits(defaultRateLimits)
+	assert.NilError(t, err)
+	assert.Equal(t, limits[rateGroupProtected], rateLimit{rate: 2, burst: 10})
+
+	for _, s := range []string{
+		"global=20:40",
+		"global=20,dynamic=5:20,protected=2:10",
+		"global=0:40,dynamic=5:20,protected=2:10",
+		"global=20:x,dynamic=5:20,protected=2:10",
+		"global=20:40,api=10:20,dynamic=5:20,protected=2:10",
+	} {
+		_, err := parseRateLimits(s)
+		if err == nil {
+			t.Errorf("parseRateLimits(%q): want error", s)
+		}
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	app := newTestApplication(t)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	tests := []struct {
+		name     string
+		role     models.Role
+		required models.Role
+		wantCode int
+	}{
+		{name: "User needs user", role: models.RoleUser, required: models.RoleUser, wantCode: http.StatusOK},
+		{name: "User needs moderator", role: models.RoleUser, required: models.RoleModerator, wantCode: http.StatusForbidden},
+		{name: "User needs admin", role: models.RoleUser, required: models.RoleAdmin, wantCode: http.StatusForbidden},
+		{name: "Moderator needs user", role: models.RoleModerator, required: models.RoleUser, wantCode: http.StatusOK},
+		{name: "Moderator needs moderator", role: models.RoleModerator, required: models.RoleModerator, wantCode: http.StatusOK},
+		{name: "Moderator needs admin", role: models.RoleModerator, required: models.RoleAdmin, wantCode: http.StatusForbidden},
+		{name: "Admin needs moderator", role: models.RoleAdmin, required: models.RoleModerator, wantCode: http.StatusOK},
+		{name: "Admin needs admin", role: models.RoleAdmin, required: models.RoleAdmin, wantCode: http.StatusOK},
+		{name: "No role", role: "", required: models.RoleUser, wantCode: http.StatusForbidden},
+		{name: "Unknown role", role: "superuser", required: models.RoleUser, wantCode: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.role != "" {
+				r = r.WithContext(context.WithValue(r.Context(), userRoleContextKey, tt.role))
+			}
+
+			app.requireRole(tt.required)(next).ServeHTTP(rr, r)
+
+			assert.Equal(t, rr.Result().StatusCode, tt.wantCode)
+		})
+	}
+
+	// Check that requireRole composes with the protected chain, and that authenticate looks up the
+	// logged-in user's role for it.
+	t.Run("Protected chain", func(t *testing.T) {
+		protected := alice.New(app.sessionManager.LoadAndSave, app.noSurf, app.authenticate, app.requireAuthentication)
+
+		mux := http.NewServeMux()
+		mux.Handle("/admin-only", protected.Append(app.requireRole(models.RoleAdmin)).Then(next))
+		mux.Handle("/", app.routes())
+
+		ts := newTestServer(t, mux)
+		defer ts.Close()
+
+		// Logged out users are sent to the login page, as for any other protected page.
+		code, header, _ := ts.get(t, "/admin-only")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+
+		_, _, body := ts.get(t, "/user/signup")
+		form := url.Values{}
+		form.Add("email", "alice@example.com")
+		form.Add("password", "pa$$word")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, _, _ = ts.postForm(t, "/user/login", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		code, _, _ = ts.get(t, "/admin-only")
+		assert.Equal(t, code, http.StatusForbidden)
+
+		// A change of role takes effect on the next request.
+		err := app.users.SetRole(1, models.RoleAdmin)
+		assert.NilError(t, err)
+
+		code, _, body = ts.get(t, "/admin-only")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, body, "OK")
+
+		err = app.users.SetRole(1, models.RoleModerator)
+		assert.NilError(t, err)
+
+		code, _, _ = ts.get(t, "/admin-only")
+		assert.Equal(t, code, http.StatusForbidden)
+	})
+}
+
+func TestAddRequestID(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.trustedProxies, _ = parseTrustedProxies("10.0.0.1")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		wantID     string
+	}{
+		{
+			name:       "No header",
+			remoteAddr: "192.0.2.1:1234",
+		},
+		{
+			name:       "Header from client",
+			remoteAddr: "192.0.2.1:1234",
+			header:     "client-chosen",
+		},
+		{
+			name:       "Header from trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			header:     "proxy-id.123",
+			wantID:     "proxy-id.123",
+		},
+		{
+			name:       "Invalid header from trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			header:     "bad id\nforged log line",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.RemoteAddr = tt.remoteAddr
+			if tt.header != "" {
+				r.Header.Set("X-Request-Id", tt.header)
+			}
+
+			var gotID string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotID = app.requestID(r)
+			})
+
+			app.addRequestID(next).ServeHTTP(rr, r)
+
+			// The handler sees the same ID that is sent back in the response.
+			assert.Equal(t, rr.Result().Header.Get("X-Request-Id"), gotID)
+
+			if tt.wantID != "" {
+				assert.Equal(t, gotID, tt.wantID)
+				return
+			}
+
+			// Otherwise it's a new random ID.
+			assert.Equal(t, len(gotID), 16)
+			if gotID == tt.header {
+				t.Errorf("request ID %q was taken from an untrusted header", gotID)
+			}
+		})
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	app := newTestApplication(t)
+
+	// The handler reads the form, and sends a 400 response if it can't, like our real handlers do.
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			app.clientError(w, r, http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(r.PostForm.Get("content")))
+	})
+
+	tests := []struct {
+		name          string
+		handler       http.Handler
+		content       string
+		contentLength bool
+		wantCode      int
+		wantBody      string
+	}{
+		{
+			name:          "Small body",
+			handler:       app.limitBody(100)(next),
+			content:       "hello",
+			contentLength: true,
+			wantCode:      http.StatusOK,
+			wantBody:      "hello",
+		},
+		{
+			name:          "Large body with Content-Length",
+			handler:       app.limitBody(100)(next),
+			content:       strings.Repeat("a", 200),
+			contentLength: true,
+			wantCode:      http.StatusRequestEntityTooLarge,
+			wantBody:      "That&#39;s more than we can accept in one go.",
+		},
+		{
+			name:     "Large body without Content-Length",
+			handler:  app.limitBody(100)(next),
+			content:  strings.Repeat("a", 200),
+			wantCode: http.StatusRequestEntityTooLarge,
+			wantBody: "That&#39;s more than we can accept in one go.",
+		},
+		{
+			// The first limit in the chain is the one which counts.
+			name:          "Route limit in front of chain limit",
+			handler:       alice.New(app.limitBody(1000), app.limitBody(100)).Then(next),
+			content:       strings.Repeat("a", 200),
+			contentLength: true,
+			wantCode:      http.StatusOK,
+			wantBody:      strings.Repeat("a", 200),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"content": {tt.content}}.Encode()
+
+			// Hiding the reader's type stops NewRequest from setting the Content-Length, so that the body
+			// is sent like a chunked one.
+			var body io.Reader = strings.NewReader(form)
+			if !tt.contentLength {
+				body = io.MultiReader(body)
+			}
+
+			r, err := http.NewRequest(http.MethodPost, "/", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			rr := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rr, r)
+
+			assert.Equal(t, rr.Code, tt.wantCode)
+			assert.StringContains(t, rr.Body.String(), tt.wantBody)
+		})
+	}
+}
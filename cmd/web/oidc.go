@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"snippetbox.felipeacosta.net/internal/models"
+	"snippetbox.felipeacosta.net/internal/oidc"
+)
+
+// oidcTimeout is how long the user has to log in with the identity provider
+// before the state we stored in their session stops being accepted.
+const oidcTimeout = 10 * time.Minute
+
+// userLoginOIDC starts single sign-on. It stores a random state (which ties
+// the callback to this session, protecting against CSRF), a nonce (which ties
+// the ID token to this login) and a PKCE code verifier in the session, and
+// sends the user off to the identity provider.
+func (app *application) userLoginOIDC(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w, r)
+		return
+	}
+
+	values := make([]string, 3)
+	for i := range values {
+		s, err := oidc.RandomString()
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		values[i] = s
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	app.sessionManager.Put(r.Context(), "oidcState", state)
+	app.sessionManager.Put(r.Context(), "oidcNonce", nonce)
+	app.sessionManager.Put(r.Context(), "oidcVerifier", verifier)
+	app.sessionManager.Put(r.Context(), "oidcExpires", time.Now().Add(oidcTimeout).Unix())
+
+	http.Redirect(w, r, app.oidc.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+}
+
+// userLoginOIDCCallback is where the identity provider sends the user back to.
+// The provider's user is matched to a local user by their (verified) email
+// address, and a new local user is created the first time they log in.
+func (app *application) userLoginOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w, r)
+		return
+	}
+
+	// Take the values out of the session straight away, so that each one can
+	// only ever be used once.
+	state := app.sessionManager.PopString(r.Context(), "oidcState")
+	nonce := app.sessionManager.PopString(r.Context(), "oidcNonce")
+	verifier := app.sessionManager.PopString(r.Context(), "oidcVerifier")
+	expires, _ := app.sessionManager.Pop(r.Context(), "oidcExpires").(int64)
+
+	q := r.URL.Query()
+
+	if state == "" || time.Now().Unix() > expires || subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(state)) != 1 {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// The user cancelled, or the provider refused to log them in.
+	if q.Get("error") != "" {
+		app.infoLog.Printf("single sign-on failed: %s: %s", q.Get("error"), q.Get("error_description"))
+		app.sessionManager.Put(r.Context(), "flash", "Single sign-on failed. Please try again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	claims, err := app.oidc.Exchange(r.Context(), q.Get("code"), verifier, nonce)
+	if err != nil {
+		if errors.Is(err, oidc.ErrInvalidToken) {
+			app.auditLog.Printf("single sign-on rejected: %v", err)
+			app.clientError(w, r, http.StatusBadRequest)
+			return
+		}
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Only trust an email address which the provider has verified. Otherwise
+	// anyone could sign up with the provider using someone else's address
+	// and take over their account here.
+	if claims.Email == "" || !claims.EmailVerified {
+		app.sessionManager.Put(r.Context(), "flash", "Your email address hasn't been verified with your identity provider.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	user, err := app.users.GetByEmail(claims.Email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	var id int
+	activated := false
+
+	if user == nil {
+		// Create the user the first time they log in. They're given a random
+		// password which no one knows; if they ever want to log in with the
+		// form, they can use the password reset link.
+		password, err := oidc.RandomString()
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		name := claims.Name
+		if name == "" {
+			name, _, _ = strings.Cut(claims.Email, "@")
+		}
+
+		id, err = app.users.Insert(name, claims.Email, password)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	} else {
+		if user.Disabled {
+			app.loginDisabled(w, r)
+			return
+		}
+
+		id = user.ID
+		activated = user.Activated
+	}
+
+	// The provider has verified the email address, so we don't need to.
+	if !activated {
+		err = app.users.Activate(id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	// Single sign-on logins are never remembered: the identity provider
+	// remembers the user instead, so logging in again is just a click.
+	app.startLogin(w, r, id, claims.Email, false)
+}
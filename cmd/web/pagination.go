@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxPage stops anyone from asking for a page so far in that calculating its
+// offset would overflow.
+const maxPage = 100000
+
+// pagination describes one page of a list, for the "pagination" partial
+// template.
+type pagination struct {
+	Page     int
+	PageSize int
+	Total    int
+	// query holds the other query string parameters (like the search), so
+	// that the links to the other pages keep them.
+	query url.Values
+}
+
+func newPagination(page, pageSize, total int, query url.Values) *pagination {
+	return &pagination{Page: page, PageSize: pageSize, Total: total, query: query}
+}
+
+// LastPage returns the number of the last page. An empty list still has one
+// (empty) page.
+func (p *pagination) LastPage() int {
+	if p.Total == 0 {
+		return 1
+	}
+	return (p.Total + p.PageSize - 1) / p.PageSize
+}
+
+func (p *pagination) HasPrevious() bool {
+	return p.Page > 1
+}
+
+func (p *pagination) HasNext() bool {
+	return p.Page < p.LastPage()
+}
+
+func (p *pagination) Previous() int {
+	return p.Page - 1
+}
+
+func (p *pagination) Next() int {
+	return p.Page + 1
+}
+
+// PageURL returns the relative URL of the given page, keeping the other query
+// string parameters.
+func (p *pagination) PageURL(page int) string {
+	q := url.Values{}
+	for key, values := range p.query {
+		q[key] = values
+	}
+	q.Set("page", strconv.Itoa(page))
+	return "?" + q.Encode()
+}
+
+// readPage reads the page number from the query string. Anything missing or
+// invalid is treated as the first page.
+func readPage(r *http.Request) int {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 || page > maxPage {
+		return 1
+	}
+	return page
+}
+
+// readSearch reads the search string from the query string, trimmed and cut
+// down to a sensible length.
+func readSearch(r *http.Request) string {
+	search := strings.TrimSpace(r.URL.Query().Get("q"))
+	if len([]rune(search)) > 100 {
+		search = string([]rune(search)[:100])
+	}
+	return search
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The route groups which can be given their own rate limits. Each request
+// counts against exactly one group's limit: the dynamic routes against the
+// dynamic limit, the protected routes (which need a login) against the
+// protected limit, and everything else, like static files and error pages,
+// against the global limit.
+const (
+	rateGroupGlobal    = "global"
+	rateGroupDynamic   = "dynamic"
+	rateGroupProtected = "protected"
+)
+
+// rateGroups lists every route group, so that the -rate-limits flag can be
+// checked against them.
+var rateGroups = []string{rateGroupGlobal, rateGroupDynamic, rateGroupProtected}
+
+// defaultRateLimits is the default value of the -rate-limits flag. Each entry
+// is group=rate:burst, where rate is the number of requests per second that a
+// single client IP can sustain and burst is how many it can make at once.
+const defaultRateLimits = "global=20:40,dynamic=5:20,protected=2:10"
+
+// rateLimit holds the limit for one route group.
+type rateLimit struct {
+	rate  float64
+	burst int
+}
+
+// parseRateLimits parses the -rate-limits flag. Every group must be given a
+// limit, and no other groups are allowed, so that a typo doesn't silently
+// leave a group unlimited.
+func parseRateLimits(s string) (map[string]rateLimit, error) {
+	limits := map[string]rateLimit{}
+
+	for _, entry := range strings.Split(s, ",") {
+		group, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q: want group=rate:burst", entry)
+		}
+
+		rateStr, burstStr, ok := strings.Cut(spec, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q: want group=rate:burst", entry)
+		}
+
+		rate, err := strconv.ParseFloat(rateStr, 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid rate in %q: must be a positive number", entry)
+		}
+
+		burst, err := strconv.Atoi(burstStr)
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("invalid burst in %q: must be a positive integer", entry)
+		}
+
+		if !isRateGroup(group) {
+			return nil, fmt.Errorf("unknown rate limit group %q", group)
+		}
+
+		limits[group] = rateLimit{rate: rate, burst: burst}
+	}
+
+	for _, group := range rateGroups {
+		if _, ok := limits[group]; !ok {
+			return nil, fmt.Errorf("no rate limit given for the %q group", group)
+		}
+	}
+
+	return limits, nil
+}
+
+// isRateGroup reports whether group is one of the route groups.
+func isRateGroup(group string) bool {
+	for _, g := range rateGroups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// bucket is the token bucket for one client IP.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a set of per-IP token buckets with the same limit. Each
+// bucket holds up to burst tokens and refills at rate tokens per second; every
+// request takes one token, and requests which find the bucket empty are
+// refused.
+type rateLimiter struct {
+	limit rateLimit
+	// now returns the current time. Tests replace it to control the clock.
+	now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(limit rateLimit) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow takes a token from the bucket for ip. If there isn't one, it returns
+// false along with how long the client should wait before retrying.
+func (l *rateLimiter) allow(ip string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.burst), last: now}
+		l.buckets[ip] = b
+	}
+
+	// Add the tokens which have dripped into the bucket since it was last
+	// used, without letting it overflow.
+	b.tokens = math.Min(float64(l.limit.burst), b.tokens+now.Sub(b.last).Seconds()*l.limit.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.limit.rate * float64(time.Second))
+		return false, wait
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+// sweep removes the buckets which have been idle long enough to fill up again.
+// A full bucket behaves exactly like a missing one, so this doesn't change
+// anyone's limit, but it stops the map growing with every IP address we've
+// ever seen. It runs at most once a minute. The caller must hold l.mu.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	refill := time.Duration(float64(l.limit.burst) / l.limit.rate * float64(time.Second))
+	for ip, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+// parseTrustedProxies parses the -trusted-proxies flag, a comma-separated list
+// of IP addresses and CIDR ranges.
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+// isTrustedProxy reports whether ip belongs to one of the trusted proxies.
+func (app *application) isTrustedProxy(ip net.IP) bool {
+	for _, network := range app.config.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// The clientIP() helper returns the IP address of the client which made the
+// request. If the request came through one of our trusted proxies, the
+// X-Forwarded-For header is read from right to left, skipping over our own
+// proxies, and the first address which isn't one of them is the client.
+// Anything to the left of that was supplied by the client and could be forged,
+// so it's ignored.
+func (app *application) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !app.isTrustedProxy(ip) {
+		return host
+	}
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			// A malformed entry means we can't trust anything further left,
+			// so treat the last proxy as the client.
+			break
+		}
+		if !app.isTrustedProxy(hop) {
+			return hop.String()
+		}
+		host = hop.String()
+	}
+
+	return host
+}
+
+// rateLimit returns middleware which applies the given group's rate limit to
+// each client IP. Only one rateLimit should be in any route's chain, so that
+// its requests are counted against one group. Requests over the limit get a 429 Too Many Requests
+// response with a Retry-After header.
+func (app *application) rateLimit(group string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limiter, ok := app.rateLimiters[group]
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if ok, wait := limiter.allow(app.clientIP(r)); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				app.clientError(w, r, http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"snippetbox.felipeacosta.net/internal/models"
+	"snippetbox.felipeacosta.net/internal/validator"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// How many snippets to show on each page of the moderation queue.
+const moderationPageSize = 25
+
+// The report form is shown below each snippet to logged-in users.
+type snippetReportForm struct {
+	Reason              string `form:"reason"`
+	Details             string `form:"details"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) snippetReportPost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	// Only users who have verified their email address can report snippets, like creating them.
+	// Otherwise anyone could sign up a few throwaway accounts and hide any snippet they liked.
+	activated, err := app.currentUserActivated(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !activated {
+		app.sessionManager.Put(r.Context(), "flash", "Please verify your email address before reporting snippets. Check your inbox for the link we sent you.")
+		http.Redirect(w, r, "/snippet/view/"+strconv.Itoa(id), http.StatusSeeOther)
+		return
+	}
+
+	// Only snippets which can be seen can be reported.
+	snippet, err := app.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	var form snippetReportForm
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(models.ReportReason(form.Reason).Valid(), "reason", "Please choose a reason")
+	form.CheckField(validator.MaxChars(form.Details, 500), "details", "This field cannot be more than 500 characters long")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Snippet = snippet
+		data.Form = form
+		data.ReportReasons = models.ReportReasons
+		app.render(w, r, http.StatusUnprocessableEntity, "view.tmpl.html", data)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	err = app.reports.Insert(id, userID, models.ReportReason(form.Reason), form.Details)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateReport) {
+			app.sessionManager.Put(r.Context(), "flash", "You've already reported this snippet.")
+			http.Redirect(w, r, "/snippet/view/"+strconv.Itoa(id), http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// Once enough people have reported a snippet, hide it straight away rather than waiting for a
+	// moderator. They can show it again by dismissing the reports.
+	if app.config.reportHideThreshold > 0 {
+		count, err := app.reports.OpenCount(id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if count >= app.config.reportHideThreshold {
+			err = app.snippets.SetHidden(id, true, models.HiddenByReports)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			err = app.recordAudit(r, "report.autohide", "snippet", id)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			app.sessionManager.Put(r.Context(), "flash", "Thanks for your report. The snippet has been hidden until a moderator reviews it.")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Thanks for your report. A moderator will review it.")
+	http.Redirect(w, r, "/snippet/view/"+strconv.Itoa(id), http.StatusSeeOther)
+}
+
+// The moderationQueue handler lists the snippets with open reports, the most
+// reported first.
+func (app *application) moderationQueue(w http.ResponseWriter, r *http.Request) {
+	page := readPage(r)
+
+	queue, total, err := app.reports.Queue(page, moderationPageSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Reports = queue
+	data.Pagination = newPagination(page, moderationPageSize, total, nil)
+	app.render(w, r, http.StatusOK, "moderation.tmpl.html", data)
+}
+
+// Resolving a snippet's reports means the moderator agrees with them, so the
+// snippet is hidden (if it wasn't already). From now on it counts as hidden by
+// a moderator, so dismissing any later reports won't show it again.
+func (app *application) moderationResolvePost(w http.ResponseWriter, r *http.Request) {
+	app.adminAction(w, r, "moderation.resolve", "snippet", "The reports of snippet #%d have been resolved and the snippet is hidden.", func(id int) error {
+		userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+		err := app.reports.Close(id, userID, models.ReportResolved)
+		if err != nil {
+			return err
+		}
+
+		return app.snippets.SetHidden(id, true, models.HiddenByModerator)
+	})
+}
+
+// Dismissing a snippet's reports means the moderator doesn't agree with them,
+// so the snippet is shown again if it was hidden automatically by the reports.
+// A snippet which an admin or a moderator hid stays hidden.
+func (app *application) moderationDismissPost(w http.ResponseWriter, r *http.Request) {
+	app.adminAction(w, r, "moderation.dismiss", "snippet", "The reports of snippet #%d have been dismissed.", func(id int) error {
+		userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+		err := app.reports.Close(id, userID, models.ReportDismissed)
+		if err != nil {
+			return err
+		}
+
+		return app.snippets.UnhideIfHiddenBy(id, models.HiddenByReports)
+	})
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 
+	"snippetbox.felipeacosta.net/internal/models"
 	"snippetbox.felipeacosta.net/ui"
 	
 	"github.com/julienschmidt/httprouter"
@@ -20,25 +22,50 @@ func (app *application) routes() http.Handler {
 	// assign it as the custom handler for 404 Not Found responses. You can also
 	// set a custom handler for 405 Method Not Aloowed responses by setting
 	// router.MethodNotALlowed in the same way too.
-	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.notFound(w)
+	// The error pages use the normal navigation, so load the session and authenticate the user first. The
+	// noSurf middleware is left out, because a POST to a missing page should be a 404 and not a CSRF failure,
+	// but csrfTokenOnly still gives the logout form in the navigation a valid token.
+	// Like everything else outside the dynamic and protected routes, they count against the global rate limit.
+	errorPages := alice.New(app.rateLimit(rateGroupGlobal), app.sessionManager.LoadAndSave, app.csrfTokenOnly, app.authenticate)
+
+	router.NotFound = errorPages.ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.notFound(w, r)
 	})
 
+	// Do the same for 405 Method Not Allowed responses, so that they get the same styled error page.
+	router.MethodNotAllowed = errorPages.ThenFunc(app.methodNotAllowed)
+
+	// If a handler panics, httprouter recovers it and calls the PanicHandler instead of letting the panic
+	// reach our recoverPanic middleware, so it needs to send the same 500 response.
+	router.PanicHandler = func(w http.ResponseWriter, r *http.Request, err any) {
+		w.Header().Set("Connection", "close")
+		app.serverError(w, r, fmt.Errorf("%s", err))
+	}
+
 	// Take the ui.Files embedded filesystem and conver it to a https.FS type so that it satisfies the http.FileSystem interface. We then pass that to the http.FileServer() function to create the file server handler.
     fileServer := http.FileServer(http.FS(ui.Files))
 
 	// Our static files are contained in the "static" folder of the ui.Files embedded filesystem. So, for example, our CSS stylesheet is located at "static/css/main.css". This means that we no longer need to strip the prefix from the request URL---any requests that start with /static/ can just be passed directly to the file server and the corresponding static file will be served (so long as it exists).
-    router.Handler(http.MethodGet, "/static/*filepath", fileServer)
+    // Wrap the file server so that precompressed variants of the static files are used when the client accepts them.
+    // Each request counts against only one rate limit, so the routes outside the dynamic and protected chains
+    // have the global limit applied here rather than in the standard chain.
+    global := alice.New(app.rateLimit(rateGroupGlobal))
+    router.Handler(http.MethodGet, "/static/*filepath", global.Then(app.serveStatic(fileServer)))
 
 	// Add a new GET /ping route.
-	router.HandlerFunc(http.MethodGet, "/ping", ping)
+	router.Handler(http.MethodGet, "/ping", global.ThenFunc(ping))
 	
 	// Create a new midleware chian containing the middleware specific to our dynamic application routes. 
 	// For now, this chain will only contain the LoadAndSave session middleware but we'll add more to it.
 	// Unprotected application routes usning the 'dynamic' middleware chain.
 	// Use the nosurf middleware on all our 'dynamic' routes.
 	// Add the authenticate() middleware to the chain.
-	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.authenticate)
+	// The size of the request body is limited before anything (like noSurf, which looks for the CSRF token)
+	// reads it.
+	session := alice.New(app.limitBody(maxFormBytes), app.sessionManager.LoadAndSave, app.noSurf, app.authenticate)
+
+	// The dynamic routes get their own, stricter, rate limit.
+	dynamic := alice.New(app.rateLimit(rateGroupDynamic)).Extend(session)
 
 	// And then create the routes using the appropriate methods, patterns and handlers.
 	// Update these routes to use the new dynamic middleware chain followed by the appropriate handler func. Note that becasue the alice ThenFunc() method returns a http.Handler (rather than a http.HanlderFunc) we also need to switch to registering the route using the route.Handler() method.
@@ -48,21 +75,71 @@ func (app *application) routes() http.Handler {
 	router.Handler(http.MethodPost, "/user/signup", dynamic.ThenFunc(app.userSignupPost))
 	router.Handler(http.MethodGet, "/user/login", dynamic.ThenFunc(app.userLogin))
 	router.Handler(http.MethodPost, "/user/login", dynamic.ThenFunc(app.userLoginPost))
+	router.Handler(http.MethodGet, "/user/password/forgot", dynamic.ThenFunc(app.userForgotPassword))
+	router.Handler(http.MethodPost, "/user/password/forgot", dynamic.ThenFunc(app.userForgotPasswordPost))
+	router.Handler(http.MethodGet, "/user/password/reset/:token", dynamic.ThenFunc(app.userResetPassword))
+	router.Handler(http.MethodPost, "/user/password/reset/:token", dynamic.ThenFunc(app.userResetPasswordPost))
+	router.Handler(http.MethodGet, "/user/verify/:token", dynamic.ThenFunc(app.userVerify))
+	router.Handler(http.MethodGet, "/user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactor))
+	router.Handler(http.MethodPost, "/user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactorPost))
+	router.Handler(http.MethodGet, "/user/login/oidc", dynamic.ThenFunc(app.userLoginOIDC))
+	router.Handler(http.MethodGet, "/user/login/oidc/callback", dynamic.ThenFunc(app.userLoginOIDCCallback))
 
 
 	// Protected (authenticated-only) application status routes, using a new 'protected'
 	// middleware chain which includes the requreAuthetication middleware.
-	// Because the 'protected' middleware chain appends to the 'dynamic' chain
-	// the noSurf middleware will also be used on the three routes below too.
-	protected := dynamic.Append(app.requireAuthentication)
+	// The 'protected' middleware chain has the same session middleware as the 'dynamic' one, so the noSurf
+	// middleware will also be used on the routes below too. It has its own rate limit instead of the
+	// dynamic one, which is checked before anything else so that refused requests are cheap.
+	protected := alice.New(app.rateLimit(rateGroupProtected)).Extend(session).Append(app.requireAuthentication)
 
     router.Handler(http.MethodGet, "/snippet/create", protected.ThenFunc(app.snippetCreate))
-	router.Handler(http.MethodPost, "/snippet/create", protected.ThenFunc(app.snippetCreatePost))
+	// The snippet form is allowed a bigger body than the others, so it goes in front of the chain's limit.
+	router.Handler(http.MethodPost, "/snippet/create", alice.New(app.limitBody(app.snippetBodyLimit())).Extend(protected).ThenFunc(app.snippetCreatePost))
+	router.Handler(http.MethodPost, "/snippet/report/:id", protected.ThenFunc(app.snippetReportPost))
+	router.Handler(http.MethodGet, "/user/snippets", protected.ThenFunc(app.userSnippets))
+	router.Handler(http.MethodPost, "/user/snippets/:id/extend", protected.ThenFunc(app.userSnippetExtendPost))
+	router.Handler(http.MethodPost, "/user/snippets/:id/delete", protected.ThenFunc(app.userSnippetDeletePost))
 	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(app.userLogoutPost))
+	router.Handler(http.MethodPost, "/user/verify/resend", protected.ThenFunc(app.userVerifyResendPost))
+	router.Handler(http.MethodGet, "/account/view", protected.ThenFunc(app.accountView))
+	router.Handler(http.MethodGet, "/account/password/update", protected.ThenFunc(app.accountPasswordUpdate))
+	router.Handler(http.MethodPost, "/account/password/update", protected.ThenFunc(app.accountPasswordUpdatePost))
+	router.Handler(http.MethodPost, "/account/delete", protected.ThenFunc(app.accountDeletePost))
+	router.Handler(http.MethodPost, "/account/sessions/revoke", protected.ThenFunc(app.accountSessionRevokePost))
+	router.Handler(http.MethodPost, "/account/sessions/revoke-all", protected.ThenFunc(app.accountSessionRevokeAllPost))
+	router.Handler(http.MethodGet, "/account/2fa", protected.ThenFunc(app.accountTwoFactor))
+	router.Handler(http.MethodGet, "/account/2fa/qr.png", protected.ThenFunc(app.accountTwoFactorQR))
+	router.Handler(http.MethodPost, "/account/2fa/enable", protected.ThenFunc(app.accountTwoFactorEnablePost))
+	router.Handler(http.MethodPost, "/account/2fa/disable", protected.ThenFunc(app.accountTwoFactorDisablePost))
+
+	// The moderation queue is for moderators (and admins, who rank above them).
+	moderator := protected.Append(app.requireRole(models.RoleModerator))
+
+	router.Handler(http.MethodGet, "/moderation", moderator.ThenFunc(app.moderationQueue))
+	router.Handler(http.MethodPost, "/moderation/:id/resolve", moderator.ThenFunc(app.moderationResolvePost))
+	router.Handler(http.MethodPost, "/moderation/:id/dismiss", moderator.ThenFunc(app.moderationDismissPost))
+
+	// The admin area is only for admins. Its forms are CSRF-protected by noSurf like all the others,
+	// because the 'admin' chain appends to the 'protected' one.
+	admin := protected.Append(app.requireRole(models.RoleAdmin))
+
+	router.Handler(http.MethodGet, "/admin", admin.ThenFunc(app.adminDashboard))
+	router.Handler(http.MethodGet, "/admin/users", admin.ThenFunc(app.adminUsers))
+	router.Handler(http.MethodPost, "/admin/users/:id/disable", admin.ThenFunc(app.adminUserDisablePost))
+	router.Handler(http.MethodPost, "/admin/users/:id/enable", admin.ThenFunc(app.adminUserEnablePost))
+	router.Handler(http.MethodPost, "/admin/users/:id/moderator/grant", admin.ThenFunc(app.adminUserGrantModeratorPost))
+	router.Handler(http.MethodPost, "/admin/users/:id/moderator/revoke", admin.ThenFunc(app.adminUserRevokeModeratorPost))
+	router.Handler(http.MethodGet, "/admin/snippets", admin.ThenFunc(app.adminSnippets))
+	router.Handler(http.MethodPost, "/admin/snippets/:id/hide", admin.ThenFunc(app.adminSnippetHidePost))
+	router.Handler(http.MethodPost, "/admin/snippets/:id/unhide", admin.ThenFunc(app.adminSnippetUnhidePost))
+	router.Handler(http.MethodPost, "/admin/snippets/:id/delete", admin.ThenFunc(app.adminSnippetDeletePost))
 
 	// Create a middleware chain containing our 'standard' middleware
 	// which will be used for every request our application recieves.
-	standard := alice.New(app.recoverPanic, app.logRequest, secureHeaders)
+	// The compress middleware comes last, so that it is as close as possible to the handlers which produce the bodies.
+	// Every request is given an ID before it's logged, so that the ID is in the log line.
+	standard := alice.New(app.recoverPanic, app.addRequestID, app.logRequest, secureHeaders, app.compress)
 
 	// Return the 'standard' middleware chain followed by the servemux
 	return standard.Then(router)
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"snippetbox.felipeacosta.net/internal/secretscan"
+)
+
+// newSecretScanner builds the scanner for the -secret-scan and
+// -secret-scan-rules flags. names is "all", "none" or a comma-separated list
+// of the built-in rules, and rulesFile is an optional file of extra rules. It
+// returns nil if there are no rules at all, which turns scanning off.
+func newSecretScanner(names, rulesFile string) (*secretscan.Scanner, error) {
+	var rules []secretscan.Rule
+
+	switch names {
+	case "all":
+		rules = secretscan.DefaultRules()
+	case "none", "":
+	default:
+		var err error
+		rules, err = secretscan.Select(secretscan.DefaultRules(), strings.Split(names, ","))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if rulesFile != "" {
+		f, err := os.Open(rulesFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		extra, err := secretscan.ParseRules(f)
+		if err != nil {
+			return nil, err
+		}
+
+		// The extra rules go first, because they're likely to be more specific
+		// than the built-in ones (and the high-entropy rule in particular).
+		rules = append(extra, rules...)
+	}
+
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	return secretscan.New(rules), nil
+}
+
+// secretsMessage returns the error message to show when a snippet looks like
+// it contains secrets. Only the first few findings are listed.
+func secretsMessage(findings []secretscan.Finding) string {
+	const maxListed = 3
+
+	var found []string
+	for i, f := range findings {
+		if i == maxListed {
+			found = append(found, fmt.Sprintf("and %d more", len(findings)-maxListed))
+			break
+		}
+		found = append(found, fmt.Sprintf("%s on line %d", f.Description, f.Line))
+	}
+
+	return fmt.Sprintf("This looks like it contains %s. Please remove it, or tick the box below if it's safe to publish.", strings.Join(found, ", "))
+}
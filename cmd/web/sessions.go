@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"snippetbox.felipeacosta.net/internal/models"
+)
+
+// sessionTouchInterval is how often a session's "last seen" time is updated.
+// Doing it on every request would mean a database write for every page view,
+// and nobody needs to know the time to the second.
+const sessionTouchInterval = time.Minute
+
+// The recordSession() helper stores the details of a newly logged-in session
+// and remembers the record's ID in the session data, so that authenticate can
+// check that the session hasn't been revoked.
+func (app *application) recordSession(r *http.Request, userID int) error {
+	expiry := app.sessionManager.Deadline(r.Context())
+
+	id, err := app.userSessions.Insert(userID, app.clientIP(r), r.UserAgent(), expiry)
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Put(r.Context(), "userSessionID", id)
+	return nil
+}
+
+// The checkSession() helper is called by authenticate for every request from
+// a logged-in user. It returns false if the user's session has been revoked
+// (or its record is missing) or, unless the user asked to be remembered, has
+// been idle for too long. Otherwise it updates the session's "last seen" time
+// now and again.
+func (app *application) checkSession(r *http.Request, userID int) (bool, error) {
+	s, err := app.userSessions.Get(app.sessionManager.GetString(r.Context(), "userSessionID"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if s.UserID != userID {
+		return false, nil
+	}
+
+	// The idle timeout is checked against the time the session was last seen, which is only updated
+	// once every sessionTouchInterval, so it's accurate to within that.
+	idle := app.config.sessionIdleTimeout
+	if idle > 0 && !app.sessionManager.GetBool(r.Context(), "rememberMe") && time.Since(s.LastSeen) > idle {
+		return false, nil
+	}
+
+	ip := app.clientIP(r)
+	if time.Since(s.LastSeen) > sessionTouchInterval || s.IP != ip {
+		err = app.userSessions.Touch(s.ID, ip)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// The logout() helper logs the current session out. It doesn't touch the
+// session record, which the caller should delete if it hasn't already.
+func (app *application) logout(r *http.Request) error {
+	// Use the RenewToken() method of the current session to change the session ID again.
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+
+	// Remove the authenticatedUserID from the session data so that the user is 'logged out'.
+	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	app.sessionManager.Remove(r.Context(), "userSessionID")
+	app.sessionManager.Remove(r.Context(), "rememberMe")
+	app.sessionManager.RememberMe(r.Context(), false)
+	return nil
+}
+
+// Create a new sessionRevokeForm struct.
+type sessionRevokeForm struct {
+	ID string `form:"id"`
+}
+
+func (app *application) accountSessionRevokePost(w http.ResponseWriter, r *http.Request) {
+	var form sessionRevokeForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	// Delete() only deletes the session if it belongs to this user, so there's no way to revoke somebody
+	// else's session by changing the ID in the form.
+	err = app.userSessions.Delete(userID, form.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", "That session has already ended.")
+			http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// Revoking the session we're using is the same as logging out.
+	if form.ID == app.sessionManager.GetString(r.Context(), "userSessionID") {
+		err = app.logout(r)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "The session has been logged out.")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// accountSessionRevokeAllPost logs the user out everywhere, including here.
+func (app *application) accountSessionRevokeAllPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	err := app.userSessions.DeleteAllForUser(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.logout(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "You've been logged out everywhere.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
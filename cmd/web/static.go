@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"snippetbox.felipeacosta.net/ui"
+
+	"github.com/andybalholm/brotli"
+)
+
+// staticAsset holds a single file from the ui.Files embedded filesystem, along
+// with its content hash and (for compressible files) its precompressed
+// variants.
+type staticAsset struct {
+	name        string
+	contentType string
+	hash        string
+	identity    []byte
+	gzip        []byte
+	brotli      []byte
+}
+
+// fingerprintedPath returns the path of the asset with its content hash added
+// before the extension, so "static/css/main.css" becomes something like
+// "static/css/main.3f2a1b9c0d4e5f6a.css".
+func (a *staticAsset) fingerprintedPath() string {
+	ext := path.Ext(a.name)
+	return strings.TrimSuffix(a.name, ext) + "." + a.hash + ext
+}
+
+// staticAssets is the manifest of everything in the "static" folder of
+// ui.Files. Files can be looked up by their plain path (for example
+// "static/css/main.css") or by their fingerprinted path.
+type staticAssets struct {
+	files         map[string]*staticAsset
+	fingerprinted map[string]*staticAsset
+}
+
+// newStaticAssets walks the "static" folder of the ui.Files embedded filesystem,
+// hashes every file and compresses every compressible one with both gzip and
+// brotli, at the highest compression level. The files never change while the
+// application is running, so this only needs doing once, at startup.
+func newStaticAssets() (*staticAssets, error) {
+	assets := &staticAssets{
+		files:         map[string]*staticAsset{},
+		fingerprinted: map[string]*staticAsset{},
+	}
+
+	err := fs.WalkDir(ui.Files, "static", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		data, err := fs.ReadFile(ui.Files, name)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+
+		asset := &staticAsset{
+			name:        name,
+			contentType: mime.TypeByExtension(path.Ext(name)),
+			hash:        hex.EncodeToString(sum[:8]),
+			identity:    data,
+		}
+		if asset.contentType == "" {
+			asset.contentType = http.DetectContentType(data)
+		}
+
+		if isCompressible(asset.contentType) {
+			if err := asset.precompress(); err != nil {
+				return err
+			}
+		}
+
+		assets.files[name] = asset
+		assets.fingerprinted[asset.fingerprintedPath()] = asset
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return assets, nil
+}
+
+// version returns a hash of the manifest: every file's path and content hash.
+// It changes whenever any of the fingerprinted URLs does.
+func (s *staticAssets) version() string {
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s\x00%s\x00", name, s.files[name].hash)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// precompress fills in the gzip and brotli variants of the asset. Variants
+// which don't come out smaller than the original are thrown away.
+func (a *staticAsset) precompress() error {
+	var gz bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&gz, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err = gw.Write(a.identity); err != nil {
+		return err
+	}
+	if err = gw.Close(); err != nil {
+		return err
+	}
+
+	var br bytes.Buffer
+	bw := brotli.NewWriterLevel(&br, brotli.BestCompression)
+	if _, err = bw.Write(a.identity); err != nil {
+		return err
+	}
+	if err = bw.Close(); err != nil {
+		return err
+	}
+
+	if gz.Len() < len(a.identity) {
+		a.gzip = gz.Bytes()
+	}
+	if br.Len() < len(a.identity) {
+		a.brotli = br.Bytes()
+	}
+	return nil
+}
+
+// The manifest is built from the embedded files, so it is the same for the
+// whole lifetime of the process. We build it once, the first time it's needed
+// (either by main() at startup or by the "static" template function), and
+// share it.
+var (
+	staticAssetsOnce sync.Once
+	sharedAssets     *staticAssets
+	sharedAssetsErr  error
+)
+
+// loadStaticAssets returns the shared manifest of static assets, building it
+// on the first call.
+func loadStaticAssets() (*staticAssets, error) {
+	staticAssetsOnce.Do(func() {
+		sharedAssets, sharedAssetsErr = newStaticAssets()
+	})
+
+	return sharedAssets, sharedAssetsErr
+}
+
+// staticURL is exposed to the templates as the "static" function. It takes the
+// path of a file relative to the static folder (like "css/main.css") and
+// returns its fingerprinted URL. Because the URL changes whenever the file's
+// contents do, browsers can cache it forever without ever seeing stale CSS
+// or JavaScript after a deploy. Asking for a file which doesn't exist is an
+// error, so a typo in a template is caught when the page is rendered.
+func staticURL(name string) (string, error) {
+	assets, err := loadStaticAssets()
+	if err != nil {
+		return "", err
+	}
+
+	asset, ok := assets.files[path.Join("static", name)]
+	if !ok {
+		return "", fmt.Errorf("static asset %q does not exist", name)
+	}
+
+	return "/" + asset.fingerprintedPath(), nil
+}
+
+// serveStatic returns a handler for the /static/*filepath route. Files in the
+// manifest are served from memory, using a precompressed variant if the client
+// accepts one. Fingerprinted paths are marked as immutable and cached for a
+// year; plain paths must be revalidated, which is cheap thanks to the ETag.
+// Anything else (like directory listings) falls through to the file server.
+func (app *application) serveStatic(fileServer http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean(r.URL.Path)[1:]
+
+		cacheControl := "no-cache"
+		asset, ok := app.staticAssets.fingerprinted[name]
+		if ok {
+			cacheControl = "public, max-age=31536000, immutable"
+		} else if asset, ok = app.staticAssets.files[name]; !ok {
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+
+		body, etag := asset.identity, asset.hash
+		if asset.gzip != nil || asset.brotli != nil {
+			addVary(w.Header(), "Accept-Encoding")
+
+			switch encoding := negotiateEncoding(r.Header.Get("Accept-Encoding")); {
+			case encoding == encodingBrotli && asset.brotli != nil:
+				body, etag = asset.brotli, asset.hash+"-br"
+				w.Header().Set("Content-Encoding", encodingBrotli)
+			case encoding == encodingGzip && asset.gzip != nil:
+				body, etag = asset.gzip, asset.hash+"-gz"
+				w.Header().Set("Content-Encoding", encodingGzip)
+			}
+		}
+
+		// Each encoding of the file is a different sequence of bytes, so each
+		// one gets its own strong ETag. Set the Content-Type explicitly,
+		// otherwise ServeContent() would sniff it from the compressed bytes.
+		w.Header().Set("ETag", `"`+etag+`"`)
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Header().Set("Content-Type", asset.contentType)
+
+		// The embedded files don't have a modification time, so pass the zero
+		// time.Time to ServeContent().
+		http.ServeContent(w, r, path.Base(name), time.Time{}, bytes.NewReader(body))
+	})
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"html/template"
 	"io/fs"
 	"path/filepath"
@@ -14,10 +17,47 @@ type templateData struct {
 	CurrentYear     int
 	Snippet         *models.Snippet
 	Snippets        []*models.Snippet
+	User            *models.User
 	Form            any
 	Flash           string
 	IsAuthenticated bool
+	IsModerator     bool
+	IsAdmin         bool
 	CSRFToken		string
+	ErrorStatus     int
+	ErrorTitle      string
+	ErrorMessage    string
+	// NeedsVerification is set when the logged-in user hasn't verified their
+	// email address yet.
+	NeedsVerification bool
+	// Two-factor authentication settings. RecoveryCodes is only set on the
+	// page which shows the codes, straight after they're generated.
+	TOTPSecret        string
+	RecoveryCodes     []string
+	RecoveryCodesLeft int
+	// SSOName is the name of the single sign-on provider, if it's switched on.
+	SSOName string
+	// The user's logged-in sessions, shown on the account page, and the ID
+	// of the one which is making this request.
+	Sessions         []*models.UserSession
+	CurrentSessionID string
+	// The admin pages. Search is what the list was searched for, and
+	// Pagination says which page of the list is being shown.
+	Users         []*models.User
+	Search        string
+	Pagination    *pagination
+	UserCounts    *models.UserCounts
+	SnippetCounts *models.SnippetCounts
+	AuditEvents   []*models.AuditEvent
+	// The reasons offered on the report form, and the moderation queue.
+	ReportReasons []struct {
+		Reason      models.ReportReason
+		Description string
+	}
+	Reports []*models.ReportedSnippet
+	// The filter and order of the user's own list of snippets.
+	SnippetStatus models.SnippetStatus
+	SnippetSort   models.SnippetSort
 }
 
 func humanDate(t time.Time) string {
@@ -31,14 +71,53 @@ func humanDate(t time.Time) string {
 }
 
 var functions = template.FuncMap{
-	"humanDate": humanDate,
+	"humanDate":     humanDate,
+	"static":        staticURL,
+	"describeAudit": describeAudit,
+}
+
+// pageVersion returns a hash of everything apart from the data which a rendered
+// page depends on: the template files in fsys and the manifest of static files
+// (whose fingerprinted URLs end up in the pages). It's mixed into the ETags of
+// the pages which anonymous viewers can cache, so that after a deploy which
+// changes either of them, the browsers' copies no longer match.
+func pageVersion(fsys fs.FS, assets *staticAssets) (string, error) {
+	h := sha256.New()
+
+	err := fs.WalkDir(fsys, "html", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(h, "%s\x00%d\x00", name, len(data))
+		h.Write(data)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Fprintf(h, "%s", assets.version())
+
+	return hex.EncodeToString(h.Sum(nil)[:8]), nil
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
+	return parseTemplates(ui.Files)
+}
+
+// The parseTemplates() function does the actual work for newTemplateCache(). It takes the filesystem to parse the templates
+// from as a parameter, so that in development mode we can parse them from the ui folder on disk instead of from ui.Files.
+func parseTemplates(fsys fs.FS) (map[string]*template.Template, error) {
 	cache := map[string]*template.Template{}
 
-	// Use fs.Glob() to get a slice of all filepaths in the ui.Files embedded filesystem which match the pattern 'html/pages/*.tmpl'. This essentially gives us a slice of all the 'page' templates for the application, just like before.
-	pages, err := fs.Glob(ui.Files, "html/pages/*.tmpl.html")
+	// Use fs.Glob() to get a slice of all filepaths in the filesystem which match the pattern 'html/pages/*.tmpl'. This essentially gives us a slice of all the 'page' templates for the application, just like before.
+	pages, err := fs.Glob(fsys, "html/pages/*.tmpl.html")
 	if err != nil {
 		return nil, err
 	}
@@ -54,7 +133,7 @@ func newTemplateCache() (map[string]*template.Template, error) {
 		}
 
 		// Use the ParseFS() instead of ParseFiles() to parse the template files from the ui.Files embedded filesystem.
-		ts, err := template.New(name).Funcs(functions).ParseFS(ui.Files, patterns...)
+		ts, err := template.New(name).Funcs(functions).ParseFS(fsys, patterns...)
 		if err != nil {
 			return nil, err
 		}
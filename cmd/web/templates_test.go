@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"snippetbox.felipeacosta.net/internal/assert"
+	"snippetbox.felipeacosta.net/internal/models"
 )
 
 
@@ -48,6 +51,81 @@ func TestHumanDate(t *testing.T) {
 }
 
 
+
+func TestDevTemplates(t *testing.T) {
+	modTime := time.Date(2022, 3, 17, 10, 15, 0, 0, time.UTC)
+
+	fsys := fstest.MapFS{
+		"html/base.tmpl.html":         {Data: []byte(`{{define "base"}}<main>{{template "main" .}}</main>{{end}}`), ModTime: modTime},
+		"html/partials/nav.tmpl.html": {Data: []byte(`{{define "nav"}}{{end}}`), ModTime: modTime},
+		"html/pages/home.tmpl.html":   {Data: []byte(`{{define "main"}}first{{end}}`), ModTime: modTime},
+	}
+
+	execute := func(t *testing.T, d *devTemplates) string {
+		t.Helper()
+
+		cache, err := d.get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := cache["home.tmpl.html"].ExecuteTemplate(&buf, "base", nil); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	d := newDevTemplates(fsys)
+	assert.Equal(t, execute(t, d), "<main>first</main>")
+
+	// Changing a page on disk means it gets reparsed on the next call.
+	fsys["html/pages/home.tmpl.html"] = &fstest.MapFile{Data: []byte(`{{define "main"}}second{{end}}`), ModTime: modTime.Add(time.Second)}
+	assert.Equal(t, execute(t, d), "<main>second</main>")
+
+	// A broken template is reported as an error, rather than the previous
+	// version being used.
+	fsys["html/pages/home.tmpl.html"] = &fstest.MapFile{Data: []byte(`{{define "main"}}{{if}}{{end}}`), ModTime: modTime.Add(2 * time.Second)}
+	_, err := d.get()
+	if err == nil {
+		t.Fatal("expected a parse error")
+	}
+}
+
+func TestPageVersion(t *testing.T) {
+	fsys := fstest.MapFS{
+		"html/base.tmpl.html": {Data: []byte(`{{define "base"}}{{end}}`)},
+	}
+
+	manifest := func(hash string) *staticAssets {
+		asset := &staticAsset{name: "static/css/main.css", hash: hash}
+		return &staticAssets{files: map[string]*staticAsset{asset.name: asset}}
+	}
+
+	etag := func(t *testing.T, fsys fstest.MapFS, assets *staticAssets) string {
+		t.Helper()
+
+		version, err := pageVersion(fsys, assets)
+		assert.NilError(t, err)
+
+		return snippetETag(&models.Snippet{ID: 1, Title: "An old silent pond"}, version)
+	}
+
+	original := etag(t, fsys, manifest("1111111111111111"))
+	assert.Equal(t, etag(t, fsys, manifest("1111111111111111")), original)
+
+	// A deploy which changes a static file, and so its fingerprinted URL, changes the ETag.
+	if etag(t, fsys, manifest("2222222222222222")) == original {
+		t.Error("ETag didn't change when the static manifest did")
+	}
+
+	// And so does one which changes a template.
+	fsys["html/base.tmpl.html"] = &fstest.MapFile{Data: []byte(`{{define "base"}}<main>{{end}}`)}
+	if etag(t, fsys, manifest("1111111111111111")) == original {
+		t.Error("ETag didn't change when a template did")
+	}
+}
+
 // func TestHumanDate(t *testing.T) {
 // 	// Initialize a new time.Time object and pass it to the humanDate function.
 // 	tm := time.Date(2022, 3, 17, 10, 15, 0, 0, time.UTC)
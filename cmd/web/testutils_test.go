@@ -13,7 +13,10 @@ import (
 	"testing"
 	"time"
 
+	"snippetbox.felipeacosta.net/internal/mailer"
 	"snippetbox.felipeacosta.net/internal/models/mocks"
+	"snippetbox.felipeacosta.net/internal/secretscan"
+	"snippetbox.felipeacosta.net/ui"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
@@ -44,6 +47,17 @@ func newTestApplication(t *testing.T) *application {
 		t.Fatal(err)
 	}
 
+	// And the manifest of static files.
+	staticAssets, err := loadStaticAssets()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := pageVersion(ui.Files, staticAssets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	// And a form decoder.
 	formDecoder := form.NewDecoder()
 
@@ -53,16 +67,40 @@ func newTestApplication(t *testing.T) *application {
 	// in-memory store, which is ideal for testing purposes.
 	sessionManager := scs.New()
 	sessionManager.Lifetime = 12 * time.Hour
+	sessionManager.Cookie.Persist = false
 	sessionManager.Cookie.Secure = true
 
 	return &application{
+		config: config{
+			compressMinSize:     1024,
+			baseURL:             "https://localhost:4000",
+			resetTokenTTL:       time.Hour,
+			verifyTokenTTL:      24 * time.Hour,
+			sessionIdleTimeout:  time.Hour,
+			rememberMeLifetime:  30 * 24 * time.Hour,
+			maxSnippetLength:    10000,
+			reportHideThreshold: 2,
+		},
 		errorLog:       log.New(io.Discard, "", 0),
 		infoLog:        log.New(io.Discard, "", 0),
+		auditLog:       log.New(io.Discard, "", 0),
 		snippets:       &mocks.SnippetModel{},
 		users:          &mocks.UserModel{},
+		tokens:         &mocks.TokenModel{},
+		recoveryCodes:  &mocks.RecoveryCodeModel{},
+		userSessions:   &mocks.UserSessionModel{},
+		audit:          &mocks.AuditModel{},
+		reports:        &mocks.ReportModel{},
+		secretScanner:  secretscan.New(secretscan.DefaultRules()),
+		verification:   verificationTokens{key: []byte("test-secret-key")},
+		verifyResends:  newResendLimiter(time.Minute),
+		loginThrottle:  newLoginThrottle(5, 20, time.Second, 15*time.Minute),
+		mailer:         &mailer.Log{Logger: log.New(io.Discard, "", 0)},
 		templateCache:  templateCache,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
+		staticAssets:   staticAssets,
+		pageVersion:    version,
 	}
 }
 
@@ -119,6 +157,29 @@ func (ts *testServer) get(t *testing.T, urlPath string) (int, http.Header, strin
 }
 
 
+// Implement a getWithHeaders() method which works like get(), but also sends
+// the given request headers (for example, If-None-Match for conditional GETs).
+func (ts *testServer) getWithHeaders(t *testing.T, urlPath string, header http.Header) (int, http.Header, string) {
+	req, err := http.NewRequest(http.MethodGet, ts.URL+urlPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = header
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rs.Body.Close()
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs.StatusCode, rs.Header, string(body)
+}
+
 // Create a postForm method for sending POST requests to the test server. The 
 // final parameter to this method is a url.Values object which can contain any form 
 // data that you want to send in the request body.
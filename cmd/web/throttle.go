@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"snippetbox.felipeacosta.net/internal/models"
+)
+
+// failureRecord tracks the failed login attempts for one account or one IP
+// address.
+type failureRecord struct {
+	failures    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// lockout describes a lockout which has just started, so that it can be
+// written to the audit log.
+type lockout struct {
+	scope    string // "account" or "ip"
+	key      string
+	failures int
+	until    time.Time
+}
+
+// loginThrottle protects userLoginPost against password guessing. Failed
+// attempts are counted both per account (so one account can't be attacked from
+// many addresses) and per client IP (so one address can't try a password
+// against many accounts). After the free attempts are used up, each further
+// failure locks the account or IP out for twice as long as the last one, up to
+// maxLockout.
+type loginThrottle struct {
+	// How many failures are allowed before the backoff starts. The IP limit is
+	// higher, because several people can share an address.
+	accountFreeAttempts int
+	ipFreeAttempts      int
+	// The length of the first lockout, and the longest any lockout can be.
+	baseDelay  time.Duration
+	maxLockout time.Duration
+	// Failures are forgotten once there haven't been any for this long.
+	forgetAfter time.Duration
+	// now returns the current time. Tests replace it to control the clock.
+	now func() time.Time
+
+	mu        sync.Mutex
+	accounts  map[string]*failureRecord
+	ips       map[string]*failureRecord
+	lastSweep time.Time
+}
+
+func newLoginThrottle(accountFreeAttempts, ipFreeAttempts int, baseDelay, maxLockout time.Duration) *loginThrottle {
+	return &loginThrottle{
+		accountFreeAttempts: accountFreeAttempts,
+		ipFreeAttempts:      ipFreeAttempts,
+		baseDelay:           baseDelay,
+		maxLockout:          maxLockout,
+		forgetAfter:         24 * time.Hour,
+		now:                 time.Now,
+		accounts:            make(map[string]*failureRecord),
+		ips:                 make(map[string]*failureRecord),
+	}
+}
+
+// accountKey normalizes an email address, so that "Alice@Example.com" and
+// "alice@example.com" share the same counter.
+func accountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// check returns how long the client must wait before they can try to log in
+// to the given account again. Zero means they can try now.
+func (t *loginThrottle) check(email, ip string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+
+	var until time.Time
+	if rec, ok := t.accounts[accountKey(email)]; ok && rec.lockedUntil.After(until) {
+		until = rec.lockedUntil
+	}
+	if rec, ok := t.ips[ip]; ok && rec.lockedUntil.After(until) {
+		until = rec.lockedUntil
+	}
+
+	if until.After(now) {
+		return until.Sub(now)
+	}
+	return 0
+}
+
+// fail records a failed attempt and returns any lockouts which it started.
+func (t *loginThrottle) fail(email, ip string) []lockout {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.sweep(now)
+
+	var lockouts []lockout
+	if until, locked := t.record(t.accounts, accountKey(email), t.accountFreeAttempts, now); locked {
+		lockouts = append(lockouts, lockout{scope: "account", key: accountKey(email), failures: t.accounts[accountKey(email)].failures, until: until})
+	}
+	if until, locked := t.record(t.ips, ip, t.ipFreeAttempts, now); locked {
+		lockouts = append(lockouts, lockout{scope: "ip", key: ip, failures: t.ips[ip].failures, until: until})
+	}
+
+	return lockouts
+}
+
+// record adds a failure to the counter for key. If that takes it over the free
+// attempts, the key is locked out and the end of the lockout is returned.
+func (t *loginThrottle) record(records map[string]*failureRecord, key string, free int, now time.Time) (time.Time, bool) {
+	rec, ok := records[key]
+	if !ok {
+		rec = &failureRecord{}
+		records[key] = rec
+	}
+
+	rec.failures++
+	rec.last = now
+
+	over := rec.failures - free
+	if over <= 0 {
+		return time.Time{}, false
+	}
+
+	// Double the delay for every failure over the limit: base, 2*base,
+	// 4*base and so on. Stop doubling once we reach the maximum, which also
+	// stops the delay from overflowing.
+	delay := t.baseDelay
+	for i := 1; i < over && delay < t.maxLockout; i++ {
+		delay *= 2
+	}
+	if delay > t.maxLockout {
+		delay = t.maxLockout
+	}
+
+	rec.lockedUntil = now.Add(delay)
+	return rec.lockedUntil, true
+}
+
+// succeed clears the failures for an account after a successful login. The
+// IP's failures are deliberately kept, otherwise an attacker could reset
+// their own counter by logging in to an account of their own now and again.
+func (t *loginThrottle) succeed(email string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.accounts, accountKey(email))
+}
+
+// sweep forgets about accounts and IPs which haven't failed for a while, so
+// that the maps don't grow forever. It does nothing if it has already run in
+// the last minute. The caller must hold t.mu.
+func (t *loginThrottle) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < time.Minute {
+		return
+	}
+	t.lastSweep = now
+
+	for _, records := range []map[string]*failureRecord{t.accounts, t.ips} {
+		for key, rec := range records {
+			if now.Sub(rec.last) > t.forgetAfter && now.After(rec.lockedUntil) {
+				delete(records, key)
+			}
+		}
+	}
+}
+
+// The loginFailed() helper counts a failed login attempt, and records any
+// lockout it caused in the audit log.
+func (app *application) loginFailed(email, ip string) {
+	for _, l := range app.loginThrottle.fail(email, ip) {
+		app.auditLog.Printf("login lockout: %s=%q failures=%d until=%s", l.scope, l.key, l.failures, l.until.UTC().Format(time.RFC3339))
+	}
+}
+
+// lockoutError is returned by checkPassword() when the password wasn't checked
+// because of a lockout. It wraps models.ErrInvalidCredentials, so that callers
+// which don't look for it still refuse the change.
+type lockoutError struct {
+	wait time.Duration
+}
+
+func (e *lockoutError) Error() string {
+	return fmt.Sprintf("locked out for %s", e.wait)
+}
+
+func (e *lockoutError) Unwrap() error {
+	return models.ErrInvalidCredentials
+}
+
+// lockedOut sets the Retry-After header for a locked out login attempt and
+// returns the error message to show on the form.
+func lockedOut(w http.ResponseWriter, wait time.Duration) string {
+	wait = wait.Round(time.Second)
+	if wait < time.Second {
+		wait = time.Second
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)))
+	return fmt.Sprintf("Too many failed login attempts. Please try again in %s.", wait)
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"time"
+
+	"snippetbox.felipeacosta.net/internal/models"
+	"snippetbox.felipeacosta.net/internal/totp"
+	"snippetbox.felipeacosta.net/internal/validator"
+
+	"rsc.io/qr"
+)
+
+// How many recovery codes a user is given when they turn on two-factor
+// authentication, and how long they have to enter their code after entering
+// their password.
+const (
+	recoveryCodeCount = 10
+	twoFactorTimeout  = 5 * time.Minute
+)
+
+// generateRecoveryCodes returns n random recovery codes like "K7QF2-MXD4A".
+// Each one has 50 bits of entropy, which is plenty for a single-use code.
+func generateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+
+	for i := range codes {
+		// 7 random bytes encode to 12 base-32 characters, of which we use
+		// the first 10.
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := base32.StdEncoding.EncodeToString(b)
+		codes[i] = s[:5] + "-" + s[5:10]
+	}
+
+	return codes, nil
+}
+
+// Create a new twoFactorEnableForm struct.
+type twoFactorEnableForm struct {
+	Code                string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
+// accountTwoFactor shows the two-factor authentication settings. If 2FA is
+// off, a new secret is generated and kept in the session until the user
+// confirms that their authenticator app has it by entering a code.
+func (app *application) accountTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+
+	if user.TOTPEnabled {
+		data.RecoveryCodesLeft, err = app.recoveryCodes.Count(user.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.Form = accountDeleteForm{}
+		app.render(w, r, http.StatusOK, "twofactor.tmpl.html", data)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "pendingTOTPSecret", secret)
+
+	data.TOTPSecret = secret
+	data.Form = twoFactorEnableForm{}
+	app.render(w, r, http.StatusOK, "twofactor.tmpl.html", data)
+}
+
+// accountTwoFactorQR sends the QR code for the pending TOTP secret as a PNG
+// image. It's generated here, rather than by a third-party service, so that
+// the secret never leaves our server.
+func (app *application) accountTwoFactorQR(w http.ResponseWriter, r *http.Request) {
+	secret := app.sessionManager.GetString(r.Context(), "pendingTOTPSecret")
+	if secret == "" {
+		app.notFound(w, r)
+		return
+	}
+
+	user, err := app.users.Get(app.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	code, err := qr.Encode(totp.URI("Snippetbox", user.Email, secret), qr.M)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(code.PNG())
+}
+
+func (app *application) accountTwoFactorEnablePost(w http.ResponseWriter, r *http.Request) {
+	var form twoFactorEnableForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	// If the secret has gone from the session (for example, because the session expired), start again.
+	secret := app.sessionManager.GetString(r.Context(), "pendingTOTPSecret")
+	if secret == "" {
+		app.sessionManager.Put(r.Context(), "flash", "Your setup session expired. Please scan the new QR code.")
+		http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		return
+	}
+
+	var counter uint64
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+	if form.Valid() {
+		var ok bool
+		counter, ok = totp.Match(secret, form.Code, time.Now())
+		form.CheckField(ok, "code", "That code isn't right. Check the time on your device and try again.")
+	}
+
+	if !form.Valid() {
+		user, err := app.users.Get(userID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		data := app.newTemplateData(r)
+		data.User = user
+		data.TOTPSecret = secret
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "twofactor.tmpl.html", data)
+		return
+	}
+
+	codes, err := generateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.recoveryCodes.Replace(userID, codes)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.users.EnableTOTP(userID, secret)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The code that was just typed in is still valid for a while, so don't let it be used to log in.
+	_, err = app.users.UseTOTPCounter(userID, counter)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.recordAudit(r, "user.2fa.enable", "user", userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Remove(r.Context(), "pendingTOTPSecret")
+
+	// Show the recovery codes straight away. Only their hashes are stored, so this is the only time the
+	// user will ever see them.
+	data := app.newTemplateData(r)
+	data.RecoveryCodes = codes
+	app.render(w, r, http.StatusOK, "recovery.tmpl.html", data)
+}
+
+func (app *application) accountTwoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	var form accountDeleteForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	// The password check is throttled like logins, so that a stolen session can't be used to guess the
+	// password and then turn two-factor authentication off.
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	status := http.StatusUnprocessableEntity
+	if form.Valid() {
+		err = app.checkPassword(r, userID, form.Password)
+
+		var lockout *lockoutError
+		switch {
+		case err == nil:
+		case errors.As(err, &lockout):
+			form.AddFieldError("password", lockedOut(w, lockout.wait))
+			status = http.StatusTooManyRequests
+		case errors.Is(err, models.ErrInvalidCredentials):
+			form.AddFieldError("password", "Password is incorrect")
+		default:
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		user, err := app.users.Get(userID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		data := app.newTemplateData(r)
+		data.User = user
+		data.RecoveryCodesLeft, err = app.recoveryCodes.Count(userID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.Form = form
+		app.render(w, r, status, "twofactor.tmpl.html", data)
+		return
+	}
+
+	err = app.users.DisableTOTP(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.recoveryCodes.DeleteAllForUser(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.recordAudit(r, "user.2fa.disable", "user", userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication has been turned off.")
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// Create a new userLoginTwoFactorForm struct. The code can be either the
+// current code from the user's authenticator app, or one of their recovery
+// codes.
+type userLoginTwoFactorForm struct {
+	Code                string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
+// pendingTwoFactorUser returns the ID of the user who has entered their
+// password but not yet their second factor, or 0 if there isn't one (or they
+// took too long).
+func (app *application) pendingTwoFactorUser(r *http.Request) int {
+	if time.Now().Unix() > app.sessionManager.GetInt64(r.Context(), "twoFactorExpires") {
+		return 0
+	}
+	return app.sessionManager.GetInt(r.Context(), "twoFactorUserID")
+}
+
+func (app *application) userLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if app.pendingTwoFactorUser(r) == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = userLoginTwoFactorForm{}
+	app.render(w, r, http.StatusOK, "login2fa.tmpl.html", data)
+}
+
+func (app *application) userLoginTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.pendingTwoFactorUser(r)
+	if userID == 0 {
+		app.sessionManager.Put(r.Context(), "flash", "Your login session expired. Please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	var form userLoginTwoFactorForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Codes are short, so guesses at them are throttled in exactly the same way as guesses at the
+	// password, and count towards the same lockout.
+	ip := app.clientIP(r)
+	if wait := app.loginThrottle.check(user.Email, ip); wait > 0 {
+		form.AddNonFieldError(lockedOut(w, wait))
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusTooManyRequests, "login2fa.tmpl.html", data)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	if form.Valid() {
+		secret, err := app.users.TOTPSecret(userID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		// A code is accepted for a few periods, so only accept it if no code for the same or a later
+		// period has been used, otherwise anyone who has seen it could use it again.
+		ok := false
+		if counter, matched := totp.Match(secret, form.Code, time.Now()); matched {
+			ok, err = app.users.UseTOTPCounter(userID, counter)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+		}
+		if !ok {
+			// It wasn't a TOTP code, so try it as a recovery code instead.
+			ok, err = app.recoveryCodes.Use(userID, form.Code)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+		}
+
+		if !ok {
+			app.loginFailed(user.Email, ip)
+
+			// Record it in the same way as a wrong password. Whoever got this far knows the password, so
+			// the account's owner needs to see it even more.
+			err = app.recordAuditAs(r, 0, "user.login.failure", "user", user.ID)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			form.AddFieldError("code", "That code isn't right")
+		}
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "login2fa.tmpl.html", data)
+		return
+	}
+
+	app.sessionManager.Remove(r.Context(), "twoFactorUserID")
+	app.sessionManager.Remove(r.Context(), "twoFactorExpires")
+	rememberMe := app.sessionManager.PopBool(r.Context(), "twoFactorRememberMe")
+
+	app.completeLogin(w, r, user.ID, user.Email, rememberMe)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"snippetbox.felipeacosta.net/internal/models"
+	"snippetbox.felipeacosta.net/internal/validator"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// How many snippets to show on each page of the user's own list.
+const userSnippetsPageSize = 20
+
+// The userSnippets handler lists the logged-in user's own snippets, including
+// the expired and hidden ones. The ?status= parameter filters them by whether
+// they've expired, and ?sort= changes the order.
+func (app *application) userSnippets(w http.ResponseWriter, r *http.Request) {
+	status, sort, page := readSnippetStatus(r), readSnippetSort(r), readPage(r)
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	snippets, total, err := app.snippets.ListForUser(userID, status, sort, page, userSnippetsPageSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Keep the filter and the order on the links to the other pages.
+	query := url.Values{}
+	if status != models.SnippetsAll {
+		query.Set("status", string(status))
+	}
+	if sort != models.SortNewest {
+		query.Set("sort", string(sort))
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	data.SnippetStatus = status
+	data.SnippetSort = sort
+	data.Pagination = newPagination(page, userSnippetsPageSize, total, query)
+	app.render(w, r, http.StatusOK, "user_snippets.tmpl.html", data)
+}
+
+// readSnippetStatus reads the status filter from the query string. Anything
+// missing or unknown means all the snippets.
+func readSnippetStatus(r *http.Request) models.SnippetStatus {
+	switch status := models.SnippetStatus(r.URL.Query().Get("status")); status {
+	case models.SnippetsActive, models.SnippetsExpired:
+		return status
+	default:
+		return models.SnippetsAll
+	}
+}
+
+// readSnippetSort reads the sort order from the query string. Anything missing
+// or unknown means newest first.
+func readSnippetSort(r *http.Request) models.SnippetSort {
+	switch sort := models.SnippetSort(r.URL.Query().Get("sort")); sort {
+	case models.SortOldest, models.SortExpires, models.SortTitle:
+		return sort
+	default:
+		return models.SortNewest
+	}
+}
+
+// The quick action forms on the list send back the page they were on, like the
+// admin forms, and the extend form also says how many days from now the
+// snippet should expire.
+type userSnippetActionForm struct {
+	ReturnTo string `form:"return_to"`
+	Expires  int    `form:"expires"`
+}
+
+// The userSnippetAction() helper does the work which is common to the quick
+// actions. It's like adminAction(), but do() is given the logged-in user's ID
+// too, so that the model can check that the snippet is theirs. Someone else's
+// snippet is a 404, the same as one which doesn't exist.
+func (app *application) userSnippetAction(w http.ResponseWriter, r *http.Request, action, flash string, do func(id, userID int, form userSnippetActionForm) error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	var form userSnippetActionForm
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// Only send the user back to their list of snippets, so that this isn't an
+	// open redirect.
+	returnTo := "/user/snippets"
+	if safeRedirectPath(form.ReturnTo) && strings.HasPrefix(form.ReturnTo, returnTo) {
+		returnTo = form.ReturnTo
+	}
+
+	err = do(id, app.sessionManager.GetInt(r.Context(), "authenticatedUserID"), form)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidExpiry):
+			app.clientError(w, r, http.StatusBadRequest)
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w, r)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.recordAudit(r, action, "snippet", id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf(flash, id))
+	http.Redirect(w, r, returnTo, http.StatusSeeOther)
+}
+
+// errInvalidExpiry is returned when the extend form asks for a number of days
+// which the create form doesn't offer.
+var errInvalidExpiry = errors.New("invalid expiry")
+
+func (app *application) userSnippetExtendPost(w http.ResponseWriter, r *http.Request) {
+	app.userSnippetAction(w, r, "snippet.extend", "The expiry of snippet #%d has been updated.", func(id, userID int, form userSnippetActionForm) error {
+		if !validator.PermittedValue(form.Expires, 1, 7, 365) {
+			return errInvalidExpiry
+		}
+		return app.snippets.ExtendExpiry(id, userID, form.Expires)
+	})
+}
+
+func (app *application) userSnippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	app.userSnippetAction(w, r, "snippet.delete", "Snippet #%d has been deleted.", func(id, userID int, form userSnippetActionForm) error {
+		return app.snippets.DeleteForUser(id, userID)
+	})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"snippetbox.felipeacosta.net/internal/mailer"
+	"snippetbox.felipeacosta.net/internal/models"
+)
+
+// errInvalidVerificationToken is returned for any verification token which is
+// malformed, has been tampered with, has expired, or belongs to an email
+// address which is no longer the user's.
+var errInvalidVerificationToken = errors.New("invalid or expired verification token")
+
+// verificationTokens creates and checks the signed links which we email to new
+// users. Unlike password reset tokens they aren't stored in the database: the
+// user ID, email address and expiry time are carried in the token itself,
+// with an HMAC-SHA256 signature so that they can't be changed.
+type verificationTokens struct {
+	key []byte
+}
+
+// sign returns the base64 encoded HMAC of payload.
+func (v verificationTokens) sign(payload string) string {
+	mac := hmac.New(sha256.New, v.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// New returns a token for the given user which expires at expiry. The email
+// address is part of the signed payload, so the link stops working if the
+// user's address changes before they click it.
+func (v verificationTokens) New(userID int, email string, expiry time.Time) string {
+	payload := fmt.Sprintf("%d.%d", userID, expiry.Unix())
+	signature := v.sign(payload + "." + email)
+	return payload + "." + signature
+}
+
+// UserID checks the token's signature and expiry, and returns the ID of the
+// user it was issued to. lookupEmail is called with that ID to get the user's
+// current email address.
+func (v verificationTokens) UserID(token string, now time.Time, lookupEmail func(id int) (string, error)) (int, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, errInvalidVerificationToken
+	}
+
+	userID, err := strconv.Atoi(parts[0])
+	if err != nil || userID < 1 {
+		return 0, errInvalidVerificationToken
+	}
+
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() > expiry {
+		return 0, errInvalidVerificationToken
+	}
+
+	email, err := lookupEmail(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Use hmac.Equal() for a constant time comparison, so that the signature
+	// can't be worked out one byte at a time from the response times.
+	want := v.sign(parts[0] + "." + parts[1] + "." + email)
+	if !hmac.Equal([]byte(parts[2]), []byte(want)) {
+		return 0, errInvalidVerificationToken
+	}
+
+	return userID, nil
+}
+
+// resendLimiter stops a user from asking for a new verification email more
+// than once every interval, so that the resend button can't be used to flood
+// someone's inbox. The state is only held in memory, which is fine for a
+// single server.
+type resendLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	last map[int]time.Time
+}
+
+func newResendLimiter(interval time.Duration) *resendLimiter {
+	return &resendLimiter{
+		interval: interval,
+		last:     make(map[int]time.Time),
+	}
+}
+
+// allow reports whether the user may be sent another email at time now. If
+// they may, now is recorded as the time of their last email. Otherwise the
+// time left until they can try again is returned.
+func (l *resendLimiter) allow(userID int, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget about anyone whose interval has passed, so that the map doesn't
+	// keep growing.
+	for id, t := range l.last {
+		if now.Sub(t) >= l.interval {
+			delete(l.last, id)
+		}
+	}
+
+	if t, ok := l.last[userID]; ok {
+		return false, l.interval - now.Sub(t)
+	}
+
+	l.last[userID] = now
+	return true, 0
+}
+
+// sendVerificationEmail emails the user a link which they can follow to
+// verify their email address.
+func (app *application) sendVerificationEmail(user *models.User) error {
+	token := app.verification.New(user.ID, user.Email, time.Now().Add(app.config.verifyTokenTTL))
+
+	return app.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Snippetbox email address",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Thanks for signing up to Snippetbox! To verify your email address, visit the\n"+
+			"link below within %s:\n\n"+
+			"%s/user/verify/%s\n\n"+
+			"If you didn't sign up, you can safely ignore this email.\n",
+			user.Name, app.config.verifyTokenTTL, app.config.baseURL, token),
+	})
+}
+
+// The currentUserActivated() helper reports whether the logged-in user has
+// verified their email address.
+func (app *application) currentUserActivated(r *http.Request) (bool, error) {
+	user, err := app.users.Get(app.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+	if err != nil {
+		return false, err
+	}
+	return user.Activated, nil
+}
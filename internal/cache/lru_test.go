@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"snippetbox.felipeacosta.net/internal/assert"
+)
+
+func TestLRU(t *testing.T) {
+	now := time.Date(2022, 3, 17, 10, 15, 0, 0, time.UTC)
+
+	newLRU := func() *LRU[int, string] {
+		c := New[int, string](2, time.Minute)
+		c.Now = func() time.Time { return now }
+		return c
+	}
+
+	t.Run("Hit and miss", func(t *testing.T) {
+		c := newLRU()
+		c.Set(1, "one")
+
+		v, ok := c.Get(1)
+		assert.Equal(t, ok, true)
+		assert.Equal(t, v, "one")
+
+		_, ok = c.Get(2)
+		assert.Equal(t, ok, false)
+
+		s := c.Stats()
+		assert.Equal(t, s.Hits, uint64(1))
+		assert.Equal(t, s.Misses, uint64(1))
+		assert.Equal(t, s.Size, 1)
+	})
+
+	t.Run("Evicts least recently used", func(t *testing.T) {
+		c := newLRU()
+		c.Set(1, "one")
+		c.Set(2, "two")
+
+		// Touch 1 so that 2 becomes the least recently used entry.
+		c.Get(1)
+		c.Set(3, "three")
+
+		_, ok := c.Get(2)
+		assert.Equal(t, ok, false)
+		_, ok = c.Get(1)
+		assert.Equal(t, ok, true)
+		_, ok = c.Get(3)
+		assert.Equal(t, ok, true)
+		assert.Equal(t, c.Stats().Evictions, uint64(1))
+	})
+
+	t.Run("Expires after TTL", func(t *testing.T) {
+		c := newLRU()
+		c.Set(1, "one")
+
+		c.Now = func() time.Time { return now.Add(time.Minute) }
+
+		_, ok := c.Get(1)
+		assert.Equal(t, ok, false)
+		assert.Equal(t, c.Stats().Size, 0)
+	})
+
+	t.Run("Delete and purge", func(t *testing.T) {
+		c := newLRU()
+		c.Set(1, "one")
+		c.Set(2, "two")
+
+		c.Delete(1)
+		_, ok := c.Get(1)
+		assert.Equal(t, ok, false)
+
+		c.Purge()
+		_, ok = c.Get(2)
+		assert.Equal(t, ok, false)
+		assert.Equal(t, c.Stats().Size, 0)
+	})
+}
@@ -0,0 +1,93 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a single plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer is the interface that the rest of the application uses to send
+// email, so that the delivery mechanism can be swapped out (for example, for
+// the Log mailer in development and tests).
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// SMTP sends email through an SMTP server. If Username is set, the PLAIN
+// authentication mechanism is used (which net/smtp only allows over TLS or to
+// localhost).
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Sender   string
+}
+
+// Send delivers the message through the SMTP server.
+func (m *SMTP) Send(msg Message) error {
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	return smtp.SendMail(addr, auth, addressOnly(m.Sender), []string{msg.To}, m.format(msg))
+}
+
+// format builds the raw RFC 5322 message, with the headers that most mail
+// servers expect.
+func (m *SMTP) format(msg Message) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "From: %s\r\n", m.Sender)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return buf.Bytes()
+}
+
+// addressOnly strips the display name from an address like
+// "Snippetbox <no-reply@snippetbox.example>", which is what the SMTP envelope
+// needs.
+func addressOnly(address string) string {
+	if start := strings.LastIndex(address, "<"); start >= 0 {
+		if end := strings.LastIndex(address, ">"); end > start {
+			return address[start+1 : end]
+		}
+	}
+	return address
+}
+
+// Log "sends" email by writing it to a logger instead. It's used when no SMTP
+// server is configured, and in tests (where the logger can write to a buffer
+// which the test then reads the message back from).
+type Log struct {
+	Logger *log.Logger
+}
+
+// Send writes the message to the logger.
+func (m *Log) Send(msg Message) error {
+	m.Logger.Printf("To: %s\nSubject: %s\n\n%s\n", msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,35 @@
+package mailer
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"snippetbox.felipeacosta.net/internal/assert"
+)
+
+func TestSMTPFormat(t *testing.T) {
+	m := &SMTP{Sender: "Snippetbox <no-reply@snippetbox.example>"}
+
+	raw := string(m.format(Message{
+		To:      "alice@example.com",
+		Subject: "Reset your password",
+		Body:    "line one\nline two",
+	}))
+
+	assert.StringContains(t, raw, "From: Snippetbox <no-reply@snippetbox.example>\r\n")
+	assert.StringContains(t, raw, "To: alice@example.com\r\n")
+	assert.StringContains(t, raw, "Subject: Reset your password\r\n")
+	assert.StringContains(t, raw, "\r\n\r\nline one\r\nline two")
+	assert.Equal(t, addressOnly(m.Sender), "no-reply@snippetbox.example")
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	m := &Log{Logger: log.New(&buf, "", 0)}
+
+	err := m.Send(Message{To: "alice@example.com", Subject: "Hello", Body: "Hi Alice"})
+	assert.NilError(t, err)
+	assert.StringContains(t, buf.String(), "To: alice@example.com")
+	assert.StringContains(t, buf.String(), "Hi Alice")
+}
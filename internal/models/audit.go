@@ -0,0 +1,93 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+type AuditModelInterface interface {
+	Insert(e *AuditEvent) error
+	Recent(limit int) ([]*AuditEvent, error)
+	ForUser(userID, limit int) ([]*AuditEvent, error)
+}
+
+// AuditEvent records something that somebody did, like an admin hiding a
+// snippet. UserID is the user who did it, or 0 if nobody was logged in.
+// TargetType and TargetID say what it was done to, for example "snippet" and
+// the snippet's ID. RequestID links the event to the request's log lines.
+type AuditEvent struct {
+	ID         int
+	UserID     int
+	Action     string
+	TargetType string
+	TargetID   int
+	IP         string
+	RequestID  string
+	Created    time.Time
+}
+
+// Define an AuditModel type which wraps a database connection pool. The audit
+// table is append-only: there are deliberately no methods to change or delete
+// events, and the user_id column has no foreign key, so that events outlive
+// the users they're about.
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// Insert adds an event to the audit table.
+func (m *AuditModel) Insert(e *AuditEvent) error {
+	stmt := `INSERT INTO audit_events (user_id, action, target_type, target_id, ip, request_id, created)
+	VALUES(?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(stmt, e.UserID, e.Action, e.TargetType, e.TargetID, e.IP, e.RequestID)
+	return err
+}
+
+// Recent returns the most recent events, newest first.
+func (m *AuditModel) Recent(limit int) ([]*AuditEvent, error) {
+	stmt := `SELECT id, user_id, action, target_type, target_id, ip, request_id, created FROM audit_events
+	ORDER BY id DESC LIMIT ?`
+
+	return m.query(stmt, limit)
+}
+
+// ForUser returns the most recent events which concern a user, newest first.
+// That's everything done to their account, by them or by anyone else (like
+// failed attempts to log in to it), and the things they did to their own
+// snippets. Things they did to other people, like an admin disabling someone,
+// aren't included.
+func (m *AuditModel) ForUser(userID, limit int) ([]*AuditEvent, error) {
+	stmt := `SELECT id, user_id, action, target_type, target_id, ip, request_id, created FROM audit_events
+	WHERE (target_type = 'user' AND target_id = ?)
+	OR (user_id = ? AND target_type = 'snippet' AND action LIKE 'snippet.%')
+	ORDER BY id DESC LIMIT ?`
+
+	return m.query(stmt, userID, userID, limit)
+}
+
+// query runs a SELECT statement for the audit_events columns above and scans
+// the rows.
+func (m *AuditModel) query(stmt string, args ...any) ([]*AuditEvent, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		e := &AuditEvent{}
+		err = rows.Scan(&e.ID, &e.UserID, &e.Action, &e.TargetType, &e.TargetID, &e.IP, &e.RequestID, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,338 @@
+package models
+
+import (
+	"time"
+
+	"snippetbox.felipeacosta.net/internal/cache"
+)
+
+// CachedSnippetModel wraps another SnippetModelInterface implementation and
+// keeps the results of the Get() and Latest() queries in memory. Any method
+// which changes the snippets table must invalidate the relevant entries.
+type CachedSnippetModel struct {
+	SnippetModelInterface
+	snippets *cache.LRU[int, *Snippet]
+	latest   *cache.LRU[struct{}, []*Snippet]
+}
+
+// NewCachedSnippetModel returns a CachedSnippetModel which holds up to size
+// snippets, each for at most ttl.
+func NewCachedSnippetModel(next SnippetModelInterface, size int, ttl time.Duration) *CachedSnippetModel {
+	return &CachedSnippetModel{
+		SnippetModelInterface: next,
+		snippets:              cache.New[int, *Snippet](size, ttl),
+		latest:                cache.New[struct{}, []*Snippet](1, ttl),
+	}
+}
+
+// Insert adds the snippet through the wrapped model and then throws away the
+// cached list of latest snippets, which no longer includes the new one.
+func (m *CachedSnippetModel) Insert(userID int, title string, content string, expires int) (int, error) {
+	id, err := m.SnippetModelInterface.Insert(userID, title, content, expires)
+	if err != nil {
+		return 0, err
+	}
+
+	m.latest.Purge()
+	return id, nil
+}
+
+// DeleteAllForUser deletes the snippets through the wrapped model. We don't
+// know which of the cached snippets belonged to the user, so both caches are
+// emptied.
+func (m *CachedSnippetModel) DeleteAllForUser(userID int) error {
+	err := m.SnippetModelInterface.DeleteAllForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	m.snippets.Purge()
+	m.latest.Purge()
+	return nil
+}
+
+// SetHidden hides or shows the snippet through the wrapped model, and then
+// forgets it and the cached list of latest snippets, so that a hidden snippet
+// disappears straight away.
+func (m *CachedSnippetModel) SetHidden(id int, hidden bool, by HiddenBy) error {
+	err := m.SnippetModelInterface.SetHidden(id, hidden, by)
+	if err != nil {
+		return err
+	}
+
+	m.snippets.Delete(id)
+	m.latest.Purge()
+	return nil
+}
+
+// UnhideIfHiddenBy may show the snippet again, so it's forgotten in the same
+// way.
+func (m *CachedSnippetModel) UnhideIfHiddenBy(id int, by HiddenBy) error {
+	err := m.SnippetModelInterface.UnhideIfHiddenBy(id, by)
+	if err != nil {
+		return err
+	}
+
+	m.snippets.Delete(id)
+	m.latest.Purge()
+	return nil
+}
+
+// Delete deletes the snippet through the wrapped model, and then forgets it
+// and the cached list of latest snippets.
+func (m *CachedSnippetModel) Delete(id int) error {
+	err := m.SnippetModelInterface.Delete(id)
+	if err != nil {
+		return err
+	}
+
+	m.snippets.Delete(id)
+	m.latest.Purge()
+	return nil
+}
+
+// ExtendExpiry changes the snippet's expiry through the wrapped model, and then
+// forgets it, so that its new expiry time is used. An expired snippet which is
+// extended comes back, so the cached list of latest snippets goes too.
+func (m *CachedSnippetModel) ExtendExpiry(id, userID, days int) error {
+	err := m.SnippetModelInterface.ExtendExpiry(id, userID, days)
+	if err != nil {
+		return err
+	}
+
+	m.snippets.Delete(id)
+	m.latest.Purge()
+	return nil
+}
+
+// DeleteForUser deletes the user's snippet through the wrapped model, and then
+// forgets it and the cached list of latest snippets.
+func (m *CachedSnippetModel) DeleteForUser(id, userID int) error {
+	err := m.SnippetModelInterface.DeleteForUser(id, userID)
+	if err != nil {
+		return err
+	}
+
+	m.snippets.Delete(id)
+	m.latest.Purge()
+	return nil
+}
+
+// Get returns the cached snippet if there is one. Because the underlying query
+// only returns unexpired snippets, a cached snippet which has expired since it
+// was stored is dropped and reported as ErrNoRecord. Only found snippets are
+// cached, so a missing ID always goes through to the wrapped model.
+func (m *CachedSnippetModel) Get(id int) (*Snippet, error) {
+	if s, ok := m.snippets.Get(id); ok {
+		if time.Now().Before(s.Expires) {
+			return s, nil
+		}
+		m.snippets.Delete(id)
+		return nil, ErrNoRecord
+	}
+
+	s, err := m.SnippetModelInterface.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	m.snippets.Set(id, s)
+	return s, nil
+}
+
+// Latest returns the cached list of latest snippets, or fetches and caches it.
+func (m *CachedSnippetModel) Latest() ([]*Snippet, error) {
+	if snippets, ok := m.latest.Get(struct{}{}); ok {
+		return snippets, nil
+	}
+
+	snippets, err := m.SnippetModelInterface.Latest()
+	if err != nil {
+		return nil, err
+	}
+
+	m.latest.Set(struct{}{}, snippets)
+	return snippets, nil
+}
+
+// Stats returns the hit/miss counters for the Get() and Latest() caches.
+func (m *CachedSnippetModel) Stats() (get, latest cache.Stats) {
+	return m.snippets.Stats(), m.latest.Stats()
+}
+
+// CachedUserModel wraps another UserModelInterface implementation and caches
+// the results of Exists() and Role(). Role() is called by the authenticate
+// middleware on every request from a logged-in user.
+type CachedUserModel struct {
+	UserModelInterface
+	exists *cache.LRU[int, bool]
+	roles  *cache.LRU[int, Role]
+}
+
+// NewCachedUserModel returns a CachedUserModel which remembers up to size user
+// IDs, each for at most ttl.
+func NewCachedUserModel(next UserModelInterface, size int, ttl time.Duration) *CachedUserModel {
+	return &CachedUserModel{
+		UserModelInterface: next,
+		exists:             cache.New[int, bool](size, ttl),
+		roles:              cache.New[int, Role](size, ttl),
+	}
+}
+
+// Exists only caches positive answers. A user ID which doesn't exist yet might
+// be created at any moment by Insert(), so "false" is always re-checked.
+func (m *CachedUserModel) Exists(id int) (bool, error) {
+	if _, ok := m.exists.Get(id); ok {
+		return true, nil
+	}
+
+	exists, err := m.UserModelInterface.Exists(id)
+	if err != nil {
+		return false, err
+	}
+
+	if exists {
+		m.exists.Set(id, true)
+	}
+	return exists, nil
+}
+
+// Role caches each user's role. Anything which changes a role must go through
+// SetRole() below, so that the cached role is forgotten straight away.
+func (m *CachedUserModel) Role(id int) (Role, error) {
+	if role, ok := m.roles.Get(id); ok {
+		return role, nil
+	}
+
+	role, err := m.UserModelInterface.Role(id)
+	if err != nil {
+		return "", err
+	}
+
+	m.roles.Set(id, role)
+	return role, nil
+}
+
+// SetRole changes the user's role through the wrapped model and forgets the
+// cached one, so that the change takes effect on the user's next request.
+func (m *CachedUserModel) SetRole(id int, role Role) error {
+	err := m.UserModelInterface.SetRole(id, role)
+	if err != nil {
+		return err
+	}
+
+	m.roles.Delete(id)
+	return nil
+}
+
+// SetDisabled disables or enables the user through the wrapped model. A
+// disabled user has no role, so the cached role is forgotten.
+func (m *CachedUserModel) SetDisabled(id int, disabled bool) error {
+	err := m.UserModelInterface.SetDisabled(id, disabled)
+	if err != nil {
+		return err
+	}
+
+	m.roles.Delete(id)
+	return nil
+}
+
+// Stats returns the hit/miss counters for the Exists() and Role() caches.
+func (m *CachedUserModel) Stats() (exists, roles cache.Stats) {
+	return m.exists.Stats(), m.roles.Stats()
+}
+
+// Delete deletes the user through the wrapped model and forgets that they
+// exist, so that their sessions stop being treated as logged in straight away.
+func (m *CachedUserModel) Delete(id int) error {
+	err := m.UserModelInterface.Delete(id)
+	if err != nil {
+		return err
+	}
+
+	m.exists.Delete(id)
+	m.roles.Delete(id)
+	return nil
+}
+
+// CachedUserSessionModel wraps another UserSessionModelInterface
+// implementation and caches the results of Get(), which is called by the
+// authenticate middleware on every request from a logged-in user. Revoking a
+// session must go through Delete() or DeleteAllForUser() below, so that the
+// cached record is forgotten and the session is logged out straight away.
+type CachedUserSessionModel struct {
+	UserSessionModelInterface
+	sessions *cache.LRU[string, *UserSession]
+}
+
+// NewCachedUserSessionModel returns a CachedUserSessionModel which holds up to
+// size session records, each for at most ttl.
+func NewCachedUserSessionModel(next UserSessionModelInterface, size int, ttl time.Duration) *CachedUserSessionModel {
+	return &CachedUserSessionModel{
+		UserSessionModelInterface: next,
+		sessions:                  cache.New[string, *UserSession](size, ttl),
+	}
+}
+
+// Get returns the cached session record if there is one. The underlying query
+// only returns unexpired sessions, so a cached record which has expired since
+// it was stored is dropped and reported as ErrNoRecord, in the same way as
+// CachedSnippetModel.Get().
+func (m *CachedUserSessionModel) Get(id string) (*UserSession, error) {
+	if s, ok := m.sessions.Get(id); ok {
+		if time.Now().Before(s.Expiry) {
+			return s, nil
+		}
+		m.sessions.Delete(id)
+		return nil, ErrNoRecord
+	}
+
+	s, err := m.UserSessionModelInterface.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	m.sessions.Set(id, s)
+	return s, nil
+}
+
+// Touch updates the session through the wrapped model and forgets the cached
+// record, so that the new "last seen" time and IP address are read back.
+func (m *CachedUserSessionModel) Touch(id, ip string) error {
+	err := m.UserSessionModelInterface.Touch(id, ip)
+	if err != nil {
+		return err
+	}
+
+	m.sessions.Delete(id)
+	return nil
+}
+
+// Delete revokes the session through the wrapped model and forgets it.
+func (m *CachedUserSessionModel) Delete(userID int, id string) error {
+	err := m.UserSessionModelInterface.Delete(userID, id)
+	if err != nil {
+		return err
+	}
+
+	m.sessions.Delete(id)
+	return nil
+}
+
+// DeleteAllForUser revokes the sessions through the wrapped model. We don't
+// know which of the cached records belonged to the user, so the whole cache is
+// emptied.
+func (m *CachedUserSessionModel) DeleteAllForUser(userID int) error {
+	err := m.UserSessionModelInterface.DeleteAllForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	m.sessions.Purge()
+	return nil
+}
+
+// Stats returns the hit/miss counters for the Get() cache.
+func (m *CachedUserSessionModel) Stats() cache.Stats {
+	return m.sessions.Stats()
+}
@@ -0,0 +1,320 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"snippetbox.felipeacosta.net/internal/assert"
+)
+
+// countingSnippetModel is a stand-in for the real SnippetModel which counts
+// how many queries actually reach it. The embedded interface is nil, so
+// calling any other method panics.
+type countingSnippetModel struct {
+	SnippetModelInterface
+	gets, latests int
+}
+
+func (m *countingSnippetModel) SetHidden(id int, hidden bool, by HiddenBy) error {
+	return nil
+}
+
+func (m *countingSnippetModel) UnhideIfHiddenBy(id int, by HiddenBy) error {
+	return nil
+}
+
+func (m *countingSnippetModel) Delete(id int) error {
+	return nil
+}
+
+func (m *countingSnippetModel) ExtendExpiry(id, userID, days int) error {
+	return nil
+}
+
+func (m *countingSnippetModel) DeleteForUser(id, userID int) error {
+	return nil
+}
+
+func (m *countingSnippetModel) Insert(userID int, title string, content string, expires int) (int, error) {
+	return 2, nil
+}
+
+func (m *countingSnippetModel) DeleteAllForUser(userID int) error {
+	return nil
+}
+
+func (m *countingSnippetModel) Get(id int) (*Snippet, error) {
+	m.gets++
+	if id != 1 {
+		return nil, ErrNoRecord
+	}
+	return &Snippet{ID: 1, Expires: time.Now().Add(time.Hour)}, nil
+}
+
+func (m *countingSnippetModel) Latest() ([]*Snippet, error) {
+	m.latests++
+	return []*Snippet{}, nil
+}
+
+func TestCachedSnippetModel(t *testing.T) {
+	next := &countingSnippetModel{}
+	m := NewCachedSnippetModel(next, 10, time.Minute)
+
+	// Repeated reads of the same snippet only hit the wrapped model once.
+	for i := 0; i < 3; i++ {
+		s, err := m.Get(1)
+		assert.NilError(t, err)
+		assert.Equal(t, s.ID, 1)
+	}
+	assert.Equal(t, next.gets, 1)
+
+	// Missing snippets are not cached.
+	for i := 0; i < 2; i++ {
+		_, err := m.Get(2)
+		assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+	}
+	assert.Equal(t, next.gets, 3)
+
+	// Latest() is cached until a new snippet is inserted.
+	m.Latest()
+	m.Latest()
+	assert.Equal(t, next.latests, 1)
+
+	_, err := m.Insert(1, "title", "content", 7)
+	assert.NilError(t, err)
+
+	m.Latest()
+	assert.Equal(t, next.latests, 2)
+
+	get, latest := m.Stats()
+	assert.Equal(t, get.Hits, uint64(2))
+	assert.Equal(t, latest.Hits, uint64(1))
+	assert.Equal(t, latest.Misses, uint64(2))
+
+	// Deleting a user's snippets empties both caches.
+	err = m.DeleteAllForUser(1)
+	assert.NilError(t, err)
+
+	m.Get(1)
+	assert.Equal(t, next.gets, 4)
+	m.Latest()
+	assert.Equal(t, next.latests, 3)
+
+	// So do hiding, extending and deleting a snippet.
+	for _, change := range []func() error{
+		func() error { return m.SetHidden(1, true, HiddenByAdmin) },
+		func() error { return m.UnhideIfHiddenBy(1, HiddenByReports) },
+		func() error { return m.ExtendExpiry(1, 1, 7) },
+		func() error { return m.Delete(1) },
+		func() error { return m.DeleteForUser(1, 1) },
+	} {
+		gets, latests := next.gets, next.latests
+
+		err = change()
+		assert.NilError(t, err)
+
+		m.Get(1)
+		assert.Equal(t, next.gets, gets+1)
+		m.Latest()
+		assert.Equal(t, next.latests, latests+1)
+	}
+}
+
+// countingUserModel is a stand-in for the real UserModel which counts how many
+// Exists() queries reach it. The embedded interface is nil, so calling any
+// other method panics.
+type countingUserModel struct {
+	UserModelInterface
+	existsCalls int
+	roleCalls   int
+	role        Role
+	deleted     map[int]bool
+}
+
+func (m *countingUserModel) Role(id int) (Role, error) {
+	m.roleCalls++
+	if m.deleted[id] {
+		return "", ErrNoRecord
+	}
+	return m.role, nil
+}
+
+func (m *countingUserModel) SetRole(id int, role Role) error {
+	m.role = role
+	return nil
+}
+
+func (m *countingUserModel) Exists(id int) (bool, error) {
+	m.existsCalls++
+	return id == 1 && !m.deleted[1], nil
+}
+
+func (m *countingUserModel) Delete(id int) error {
+	m.deleted[id] = true
+	return nil
+}
+
+func TestCachedUserModel(t *testing.T) {
+	next := &countingUserModel{deleted: map[int]bool{}}
+	m := NewCachedUserModel(next, 10, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		exists, err := m.Exists(1)
+		assert.NilError(t, err)
+		assert.Equal(t, exists, true)
+	}
+	assert.Equal(t, next.existsCalls, 1)
+
+	// Once the user has been deleted, the cached answer is forgotten.
+	err := m.Delete(1)
+	assert.NilError(t, err)
+
+	exists, err := m.Exists(1)
+	assert.NilError(t, err)
+	assert.Equal(t, exists, false)
+	assert.Equal(t, next.existsCalls, 2)
+}
+
+func TestCachedUserModelRole(t *testing.T) {
+	next := &countingUserModel{role: RoleUser, deleted: map[int]bool{}}
+	m := NewCachedUserModel(next, 10, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		role, err := m.Role(1)
+		assert.NilError(t, err)
+		assert.Equal(t, role, RoleUser)
+	}
+	assert.Equal(t, next.roleCalls, 1)
+
+	// Changing the role forgets the cached one.
+	err := m.SetRole(1, RoleAdmin)
+	assert.NilError(t, err)
+
+	role, err := m.Role(1)
+	assert.NilError(t, err)
+	assert.Equal(t, role, RoleAdmin)
+	assert.Equal(t, next.roleCalls, 2)
+
+	// And so does deleting the user.
+	err = m.Delete(1)
+	assert.NilError(t, err)
+
+	_, err = m.Role(1)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+}
+
+func TestRoleAtLeast(t *testing.T) {
+	tests := []struct {
+		role Role
+		min  Role
+		want bool
+	}{
+		{role: RoleUser, min: RoleUser, want: true},
+		{role: RoleUser, min: RoleModerator, want: false},
+		{role: RoleModerator, min: RoleUser, want: true},
+		{role: RoleModerator, min: RoleAdmin, want: false},
+		{role: RoleAdmin, min: RoleModerator, want: true},
+		{role: "", min: RoleUser, want: false},
+		{role: "root", min: RoleUser, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.role)+">="+string(tt.min), func(t *testing.T) {
+			assert.Equal(t, tt.role.AtLeast(tt.min), tt.want)
+		})
+	}
+}
+
+// countingUserSessionModel is a stand-in for the real UserSessionModel which
+// counts how many Get() queries reach it.
+type countingUserSessionModel struct {
+	UserSessionModelInterface
+	getCalls int
+	sessions map[string]*UserSession
+}
+
+func (m *countingUserSessionModel) Get(id string) (*UserSession, error) {
+	m.getCalls++
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNoRecord
+	}
+	return s, nil
+}
+
+func (m *countingUserSessionModel) Touch(id, ip string) error {
+	m.sessions[id].IP = ip
+	return nil
+}
+
+func (m *countingUserSessionModel) Delete(userID int, id string) error {
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *countingUserSessionModel) DeleteAllForUser(userID int) error {
+	for id, s := range m.sessions {
+		if s.UserID == userID {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+func TestCachedUserSessionModel(t *testing.T) {
+	expiry := time.Now().Add(time.Hour)
+	next := &countingUserSessionModel{sessions: map[string]*UserSession{
+		"A": {ID: "A", UserID: 1, Expiry: expiry, IP: "192.0.2.1"},
+		"B": {ID: "B", UserID: 1, Expiry: expiry},
+		"C": {ID: "C", UserID: 2, Expiry: expiry},
+		"D": {ID: "D", UserID: 2, Expiry: time.Now().Add(50 * time.Millisecond)},
+	}}
+	m := NewCachedUserSessionModel(next, 10, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		s, err := m.Get("A")
+		assert.NilError(t, err)
+		assert.Equal(t, s.UserID, 1)
+	}
+	assert.Equal(t, next.getCalls, 1)
+
+	// Touching the session forgets the cached record, so the new IP address is
+	// read back.
+	err := m.Touch("A", "192.0.2.2")
+	assert.NilError(t, err)
+
+	s, err := m.Get("A")
+	assert.NilError(t, err)
+	assert.Equal(t, s.IP, "192.0.2.2")
+	assert.Equal(t, next.getCalls, 2)
+
+	// A revoked session is logged out straight away.
+	err = m.Delete(1, "A")
+	assert.NilError(t, err)
+
+	_, err = m.Get("A")
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	// And so are all of a user's sessions, but not anyone else's.
+	_, err = m.Get("B")
+	assert.NilError(t, err)
+	_, err = m.Get("C")
+	assert.NilError(t, err)
+
+	err = m.DeleteAllForUser(1)
+	assert.NilError(t, err)
+
+	_, err = m.Get("B")
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+	_, err = m.Get("C")
+	assert.NilError(t, err)
+
+	// A cached session which has since expired isn't returned.
+	_, err = m.Get("D")
+	assert.NilError(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	_, err = m.Get("D")
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+}
@@ -14,4 +14,7 @@ var (
 	// Add a new ErrDuplicateEmail error. We'll use this if a user
 	// tries to signup with an email address that's already in use.
 	ErrDuplicateEmail = errors.New("models: duplicate email")
+
+	// ErrDuplicateReport is returned if a user reports the same snippet twice.
+	ErrDuplicateReport = errors.New("models: duplicate report")
 )
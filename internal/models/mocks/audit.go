@@ -0,0 +1,58 @@
+package mocks
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"snippetbox.felipeacosta.net/internal/models"
+)
+
+// AuditModel keeps the audit events in memory, so that tests can check what
+// was recorded.
+type AuditModel struct {
+	mu     sync.Mutex
+	events []*models.AuditEvent
+}
+
+func (m *AuditModel) Insert(e *models.AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	copy := *e
+	copy.ID = len(m.events) + 1
+	copy.Created = time.Now()
+	m.events = append(m.events, &copy)
+
+	return nil
+}
+
+func (m *AuditModel) Recent(limit int) ([]*models.AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := []*models.AuditEvent{}
+	for i := len(m.events) - 1; i >= 0 && len(events) < limit; i-- {
+		copy := *m.events[i]
+		events = append(events, &copy)
+	}
+
+	return events, nil
+}
+
+func (m *AuditModel) ForUser(userID, limit int) ([]*models.AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := []*models.AuditEvent{}
+	for i := len(m.events) - 1; i >= 0 && len(events) < limit; i-- {
+		e := m.events[i]
+		if (e.TargetType == "user" && e.TargetID == userID) ||
+			(e.UserID == userID && e.TargetType == "snippet" && strings.HasPrefix(e.Action, "snippet.")) {
+			copy := *e
+			events = append(events, &copy)
+		}
+	}
+
+	return events, nil
+}
@@ -0,0 +1,61 @@
+package mocks
+
+import (
+	"strings"
+	"sync"
+)
+
+// RecoveryCodeModel keeps recovery codes in memory, so that tests can use the
+// codes shown when two-factor authentication is turned on.
+type RecoveryCodeModel struct {
+	mu    sync.Mutex
+	codes map[int]map[string]bool
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func (m *RecoveryCodeModel) Replace(userID int, codes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.codes == nil {
+		m.codes = map[int]map[string]bool{}
+	}
+
+	m.codes[userID] = map[string]bool{}
+	for _, code := range codes {
+		m.codes[userID][normalizeRecoveryCode(code)] = true
+	}
+
+	return nil
+}
+
+func (m *RecoveryCodeModel) Use(userID int, code string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	code = normalizeRecoveryCode(code)
+	if !m.codes[userID][code] {
+		return false, nil
+	}
+
+	delete(m.codes[userID], code)
+	return true, nil
+}
+
+func (m *RecoveryCodeModel) Count(userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.codes[userID]), nil
+}
+
+func (m *RecoveryCodeModel) DeleteAllForUser(userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.codes, userID)
+	return nil
+}
@@ -0,0 +1,128 @@
+package mocks
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"snippetbox.felipeacosta.net/internal/models"
+)
+
+type mockReport struct {
+	snippetID int
+	userID    int
+	reason    models.ReportReason
+	status    models.ReportStatus
+	created   time.Time
+}
+
+// ReportModel keeps the reports in memory, so that tests can report a snippet
+// and then moderate it.
+type ReportModel struct {
+	mu      sync.Mutex
+	reports []*mockReport
+}
+
+func (m *ReportModel) Insert(snippetID, userID int, reason models.ReportReason, details string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range m.reports {
+		if r.snippetID == snippetID && r.userID == userID && r.status == models.ReportOpen {
+			return models.ErrDuplicateReport
+		}
+	}
+
+	m.reports = append(m.reports, &mockReport{
+		snippetID: snippetID,
+		userID:    userID,
+		reason:    reason,
+		status:    models.ReportOpen,
+		created:   time.Now(),
+	})
+	return nil
+}
+
+func (m *ReportModel) OpenCount(snippetID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, r := range m.reports {
+		if r.snippetID == snippetID && r.status == models.ReportOpen {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *ReportModel) Queue(page, pageSize int) ([]*models.ReportedSnippet, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	bySnippet := map[int]*models.ReportedSnippet{}
+	queue := []*models.ReportedSnippet{}
+
+	for _, r := range m.reports {
+		if r.status != models.ReportOpen {
+			continue
+		}
+
+		rs, ok := bySnippet[r.snippetID]
+		if !ok {
+			rs = &models.ReportedSnippet{SnippetID: r.snippetID}
+			if r.snippetID == mockSnippet.ID {
+				rs.Title = mockSnippet.Title
+			}
+			bySnippet[r.snippetID] = rs
+			queue = append(queue, rs)
+		}
+
+		rs.Reports++
+		rs.LastReported = r.created
+		if !containsReason(rs.Reasons, r.reason) {
+			rs.Reasons = append(rs.Reasons, r.reason)
+		}
+	}
+
+	sort.SliceStable(queue, func(i, j int) bool { return queue[i].Reports > queue[j].Reports })
+
+	total := len(queue)
+	start := (page - 1) * pageSize
+	if start >= total {
+		return []*models.ReportedSnippet{}, total, nil
+	}
+	end := start + pageSize
+	if end > total {
+		end = total
+	}
+
+	return queue[start:end], total, nil
+}
+
+func (m *ReportModel) Close(snippetID, moderatorID int, status models.ReportStatus) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	closed := false
+	for _, r := range m.reports {
+		if r.snippetID == snippetID && r.status == models.ReportOpen {
+			r.status = status
+			closed = true
+		}
+	}
+
+	if !closed {
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+func containsReason(reasons []models.ReportReason, reason models.ReportReason) bool {
+	for _, r := range reasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package mocks
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"snippetbox.felipeacosta.net/internal/models"
+)
+
+// UserSessionModel keeps session records in memory, so that tests can list
+// and revoke the sessions they log in with.
+type UserSessionModel struct {
+	mu       sync.Mutex
+	next     int
+	sessions map[string]*models.UserSession
+}
+
+func (m *UserSessionModel) Insert(userID int, ip, userAgent string, expiry time.Time) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.sessions == nil {
+		m.sessions = map[string]*models.UserSession{}
+	}
+
+	m.next++
+	now := time.Now()
+	s := &models.UserSession{
+		ID:        fmt.Sprintf("SESSION%d", m.next),
+		UserID:    userID,
+		Created:   now,
+		LastSeen:  now,
+		Expiry:    expiry,
+		IP:        ip,
+		UserAgent: userAgent,
+	}
+	m.sessions[s.ID] = s
+
+	return s.ID, nil
+}
+
+func (m *UserSessionModel) Get(id string) (*models.UserSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok || time.Now().After(s.Expiry) {
+		return nil, models.ErrNoRecord
+	}
+
+	copy := *s
+	return &copy, nil
+}
+
+func (m *UserSessionModel) Touch(id, ip string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[id]; ok {
+		s.LastSeen = time.Now()
+		s.IP = ip
+	}
+	return nil
+}
+
+func (m *UserSessionModel) AllForUser(userID int) ([]*models.UserSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sessions := []*models.UserSession{}
+	for _, s := range m.sessions {
+		if s.UserID == userID && time.Now().Before(s.Expiry) {
+			copy := *s
+			sessions = append(sessions, &copy)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+
+	return sessions, nil
+}
+
+func (m *UserSessionModel) Delete(userID int, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok || s.UserID != userID {
+		return models.ErrNoRecord
+	}
+
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *UserSessionModel) DeleteAllForUser(userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, s := range m.sessions {
+		if s.UserID == userID {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package mocks
 
 import(
+	"strings"
+	"sync"
 	"time"
 
 	"snippetbox.felipeacosta.net/internal/models"
@@ -14,9 +16,23 @@ var mockSnippet = &models.Snippet{
 	Expires: time.Now(),
 }
 
-type SnippetModel struct{}
+// SnippetModel keeps track of whether the mock snippet is hidden, and who hid
+// it, so that tests can hide it and then check that it stays hidden.
+type SnippetModel struct {
+	mu       sync.Mutex
+	hiddenBy models.HiddenBy
+}
+
+// snippet returns a copy of the mock snippet with its hidden state. The caller
+// must hold m.mu.
+func (m *SnippetModel) snippet() *models.Snippet {
+	s := *mockSnippet
+	s.Hidden = m.hiddenBy != ""
+	s.HiddenBy = m.hiddenBy
+	return &s
+}
 
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
+func (m *SnippetModel) Insert(userID int, title string, content string, expires int) (int, error) {
 	return 2, nil
 }
 
@@ -32,3 +48,90 @@ func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
 func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
 	return []*models.Snippet{mockSnippet}, nil
 }
+
+func (m *SnippetModel) DeleteAllForUser(userID int) error {
+	return nil
+}
+
+func (m *SnippetModel) List(search string, page, pageSize int) ([]*models.Snippet, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if page > 1 || !strings.Contains(strings.ToLower(mockSnippet.Title+mockSnippet.Content), strings.ToLower(search)) {
+		return []*models.Snippet{}, 0, nil
+	}
+	return []*models.Snippet{m.snippet()}, 1, nil
+}
+
+func (m *SnippetModel) Counts() (*models.SnippetCounts, error) {
+	return &models.SnippetCounts{Total: 1, Active: 1}, nil
+}
+
+func (m *SnippetModel) SetHidden(id int, hidden bool, by models.HiddenBy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch id {
+	case 1:
+		m.hiddenBy = ""
+		if hidden {
+			m.hiddenBy = by
+		}
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) UnhideIfHiddenBy(id int, by models.HiddenBy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch id {
+	case 1:
+		if m.hiddenBy == by {
+			m.hiddenBy = ""
+		}
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) Delete(id int) error {
+	switch id {
+	case 1:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+// The mock snippet belongs to Alice (user 1).
+func (m *SnippetModel) ListForUser(userID int, status models.SnippetStatus, sort models.SnippetSort, page, pageSize int) ([]*models.Snippet, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case userID != 1 || page > 1:
+		return []*models.Snippet{}, 0, nil
+	case status == models.SnippetsActive && mockSnippet.Expired(), status == models.SnippetsExpired && !mockSnippet.Expired():
+		return []*models.Snippet{}, 0, nil
+	default:
+		return []*models.Snippet{m.snippet()}, 1, nil
+	}
+}
+
+func (m *SnippetModel) ExtendExpiry(id, userID, days int) error {
+	if id == 1 && userID == 1 {
+		return nil
+	}
+	return models.ErrNoRecord
+}
+
+func (m *SnippetModel) DeleteForUser(id, userID int) error {
+	if id == 1 && userID == 1 {
+		return nil
+	}
+	return models.ErrNoRecord
+}
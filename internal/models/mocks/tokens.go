@@ -0,0 +1,62 @@
+package mocks
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"snippetbox.felipeacosta.net/internal/models"
+)
+
+type mockToken struct {
+	userID int
+	scope  string
+}
+
+// TokenModel keeps tokens in memory, so that tests can follow a token from
+// the email it was sent in through to the page which uses it.
+type TokenModel struct {
+	mu     sync.Mutex
+	next   int
+	tokens map[string]mockToken
+}
+
+func (m *TokenModel) New(userID int, ttl time.Duration, scope string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.tokens == nil {
+		m.tokens = map[string]mockToken{}
+	}
+
+	m.next++
+	plaintext := fmt.Sprintf("MOCKTOKEN%017d", m.next)
+	m.tokens[plaintext] = mockToken{userID: userID, scope: scope}
+
+	return plaintext, nil
+}
+
+func (m *TokenModel) UserID(scope, plaintext string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tokens[plaintext]
+	if !ok || t.scope != scope {
+		return 0, models.ErrNoRecord
+	}
+
+	return t.userID, nil
+}
+
+func (m *TokenModel) DeleteAllForUser(scope string, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for plaintext, t := range m.tokens {
+		if t.scope == scope && t.userID == userID {
+			delete(m.tokens, plaintext)
+		}
+	}
+
+	return nil
+}
@@ -1,17 +1,47 @@
 package mocks
 
 import (
+	"strings"
+	"sync"
+	"time"
+
 	"snippetbox.felipeacosta.net/internal/models"
 )
 
-type UserModel struct{}
+var mockUser = &models.User{
+	ID:        1,
+	Name:      "Alice Jones",
+	Email:     "alice@example.com",
+	Created:   time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC),
+	Activated: true,
+}
+
+// mockUnverifiedUser has signed up but not verified their email address yet.
+var mockUnverifiedUser = &models.User{
+	ID:        2,
+	Name:      "Bob Smith",
+	Email:     "bob@example.com",
+	Created:   time.Date(2022, 1, 2, 10, 0, 0, 0, time.UTC),
+	Activated: false,
+}
+
+// UserModel keeps the users' TOTP secrets in memory, so that tests can turn
+// on two-factor authentication and then log in with it.
+type UserModel struct {
+	mu          sync.Mutex
+	totpSecrets map[int]string
+	totpCounter map[int]uint64
+	roles       map[int]models.Role
+	disabled    map[int]bool
+	activated   map[int]bool
+}
 
-func (m *UserModel) Insert(name, email, password string) error {
+func (m *UserModel) Insert(name, email, password string) (int, error) {
 	switch email {
 	case "dupe@example.com":
-		return models.ErrDuplicateEmail
+		return 0, models.ErrDuplicateEmail
 	default:
-		return nil
+		return 3, nil
 	}
 }
 
@@ -19,15 +49,224 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 	if email == "alice@example.com" && password == "pa$$word" {
 		return 1, nil
 	}
+	if email == "bob@example.com" && password == "pa$$word" {
+		return 2, nil
+	}
 
 	return 0, models.ErrInvalidCredentials
 }
 
 func (m *UserModel) Exists(id int) (bool, error) {
 	switch id {
-	case 1:
+	case 1, 2:
 		return true, nil
 	default:
 		return false, nil
 	}
 }
+
+func (m *UserModel) Get(id int) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var u models.User
+	switch id {
+	case 1:
+		u = *mockUser
+	case 2:
+		u = *mockUnverifiedUser
+	default:
+		return nil, models.ErrNoRecord
+	}
+
+	u.TOTPEnabled = m.totpSecrets[id] != ""
+	u.Role = m.role(id)
+	u.Disabled = m.disabled[id]
+	u.Activated = u.Activated || m.activated[id]
+	return &u, nil
+}
+
+func (m *UserModel) GetByEmail(email string) (*models.User, error) {
+	switch email {
+	case "alice@example.com":
+		return m.Get(1)
+	case "bob@example.com":
+		return m.Get(2)
+	default:
+		return nil, models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) UpdatePassword(id int, password string) error {
+	switch id {
+	case 1, 2:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) Activate(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch id {
+	case 1, 2, 3:
+		if m.activated == nil {
+			m.activated = map[int]bool{}
+		}
+		m.activated[id] = true
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) Delete(id int) error {
+	switch id {
+	case 1, 2:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) EnableTOTP(id int, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.totpSecrets == nil {
+		m.totpSecrets = map[int]string{}
+	}
+	m.totpSecrets[id] = secret
+	delete(m.totpCounter, id)
+
+	return nil
+}
+
+func (m *UserModel) DisableTOTP(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.totpSecrets, id)
+	return nil
+}
+
+func (m *UserModel) TOTPSecret(id int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch id {
+	case 1, 2, 3:
+		return m.totpSecrets[id], nil
+	default:
+		return "", models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) UseTOTPCounter(id int, counter uint64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if counter <= m.totpCounter[id] {
+		return false, nil
+	}
+	if m.totpCounter == nil {
+		m.totpCounter = map[int]uint64{}
+	}
+	m.totpCounter[id] = counter
+
+	return true, nil
+}
+
+// role returns the user's role. Everyone is a plain user unless a test has
+// given them another role with SetRole. The caller must hold m.mu.
+func (m *UserModel) role(id int) models.Role {
+	if role, ok := m.roles[id]; ok {
+		return role
+	}
+	return models.RoleUser
+}
+
+func (m *UserModel) Role(id int) (models.Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch id {
+	case 1, 2:
+		if m.disabled[id] {
+			return "", models.ErrNoRecord
+		}
+		return m.role(id), nil
+	default:
+		return "", models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) SetRole(id int, role models.Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch id {
+	case 1, 2:
+		if m.roles == nil {
+			m.roles = map[int]models.Role{}
+		}
+		m.roles[id] = role
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) SetDisabled(id int, disabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch id {
+	case 1, 2:
+		if m.disabled == nil {
+			m.disabled = map[int]bool{}
+		}
+		m.disabled[id] = disabled
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) List(search string, page, pageSize int) ([]*models.User, int, error) {
+	users := []*models.User{}
+	total := 0
+
+	// Newest first, like the real model.
+	for _, id := range []int{2, 1} {
+		u, err := m.Get(id)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !strings.Contains(strings.ToLower(u.Name+" "+u.Email), strings.ToLower(search)) {
+			continue
+		}
+
+		total++
+		if total > (page-1)*pageSize && len(users) < pageSize {
+			users = append(users, u)
+		}
+	}
+
+	return users, total, nil
+}
+
+func (m *UserModel) Counts() (*models.UserCounts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := &models.UserCounts{Total: 2}
+	for _, disabled := range m.disabled {
+		if disabled {
+			c.Disabled++
+		}
+	}
+	return c, nil
+}
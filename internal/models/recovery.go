@@ -0,0 +1,92 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"strings"
+)
+
+type RecoveryCodeModelInterface interface {
+	Replace(userID int, codes []string) error
+	Use(userID int, code string) (bool, error)
+	Count(userID int) (int, error)
+	DeleteAllForUser(userID int) error
+}
+
+// Define a RecoveryCodeModel type which wraps a database connection pool.
+// Recovery codes let a user log in if they lose the device with their
+// authenticator app on it. Like tokens, only their SHA-256 hashes are stored.
+type RecoveryCodeModel struct {
+	DB *sql.DB
+}
+
+// hashRecoveryCode normalizes a recovery code before hashing it, so that it
+// still matches if the user types it in lower case or leaves out the dash.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+// Replace deletes all of a user's recovery codes and stores the new ones in
+// their place, in a single transaction.
+func (m *RecoveryCodeModel) Replace(userID int, codes []string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	// Rollback() does nothing if the transaction has already been committed.
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		_, err = tx.Exec("INSERT INTO recovery_codes (user_id, hash) VALUES(?, ?)", userID, hashRecoveryCode(code))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Use checks a recovery code and, if it's valid, deletes it so that it can't
+// be used again. Deleting the row is the check: if a row was deleted the code
+// was valid, which also means two requests can't both use the same code.
+func (m *RecoveryCodeModel) Use(userID int, code string) (bool, error) {
+	stmt := "DELETE FROM recovery_codes WHERE user_id = ? AND hash = ?"
+
+	result, err := m.DB.Exec(stmt, userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// Count returns how many unused recovery codes a user has left.
+func (m *RecoveryCodeModel) Count(userID int) (int, error) {
+	var count int
+
+	stmt := "SELECT COUNT(*) FROM recovery_codes WHERE user_id = ?"
+
+	err := m.DB.QueryRow(stmt, userID).Scan(&count)
+	return count, err
+}
+
+// DeleteAllForUser deletes all of a user's recovery codes, for when they turn
+// off two-factor authentication.
+func (m *RecoveryCodeModel) DeleteAllForUser(userID int) error {
+	stmt := "DELETE FROM recovery_codes WHERE user_id = ?"
+
+	_, err := m.DB.Exec(stmt, userID)
+	return err
+}
@@ -0,0 +1,182 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ReportReason is the category a user picks when they report a snippet.
+type ReportReason string
+
+const (
+	ReasonSpam        ReportReason = "spam"
+	ReasonCredentials ReportReason = "credentials"
+	ReasonOffensive   ReportReason = "offensive"
+	ReasonOther       ReportReason = "other"
+)
+
+// ReportReasons lists the reasons in the order they're shown on the report
+// form, with their descriptions.
+var ReportReasons = []struct {
+	Reason      ReportReason
+	Description string
+}{
+	{ReasonSpam, "Spam or advertising"},
+	{ReasonCredentials, "Leaked passwords, keys or personal data"},
+	{ReasonOffensive, "Offensive or abusive content"},
+	{ReasonOther, "Something else"},
+}
+
+// Valid reports whether r is one of the reasons above.
+func (r ReportReason) Valid() bool {
+	for _, reason := range ReportReasons {
+		if reason.Reason == r {
+			return true
+		}
+	}
+	return false
+}
+
+// ReportStatus says whether a moderator has dealt with a report yet. A report
+// is resolved if the moderator agreed with it, and dismissed if they didn't.
+type ReportStatus string
+
+const (
+	ReportOpen      ReportStatus = "open"
+	ReportResolved  ReportStatus = "resolved"
+	ReportDismissed ReportStatus = "dismissed"
+)
+
+type ReportModelInterface interface {
+	Insert(snippetID, userID int, reason ReportReason, details string) error
+	OpenCount(snippetID int) (int, error)
+	Queue(page, pageSize int) ([]*ReportedSnippet, int, error)
+	Close(snippetID, moderatorID int, status ReportStatus) error
+}
+
+// ReportedSnippet is one entry in the moderation queue: a snippet with open
+// reports, how many there are, and which reasons were given.
+type ReportedSnippet struct {
+	SnippetID    int
+	Title        string
+	Hidden       bool
+	Reports      int
+	Reasons      []ReportReason
+	LastReported time.Time
+}
+
+// Define a ReportModel type which wraps a database connection pool.
+type ReportModel struct {
+	DB *sql.DB
+}
+
+// Insert adds a report. If the user already has an open report of the
+// snippet, it returns ErrDuplicateReport, so that nobody can push a snippet
+// over the automatic hiding threshold on their own.
+func (m *ReportModel) Insert(snippetID, userID int, reason ReportReason, details string) error {
+	stmt := `INSERT INTO reports (snippet_id, user_id, reason, details, created)
+	VALUES(?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(stmt, snippetID, userID, string(reason), details)
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) {
+			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "reports_uc_snippet_user") {
+				return ErrDuplicateReport
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// OpenCount returns the number of open reports for a snippet. Only reports
+// from users who have verified their email address are counted.
+func (m *ReportModel) OpenCount(snippetID int) (int, error) {
+	var count int
+
+	stmt := `SELECT COUNT(*) FROM reports r JOIN users u ON u.id = r.user_id
+	WHERE r.snippet_id = ? AND r.status = 'open' AND u.activated`
+
+	err := m.DB.QueryRow(stmt, snippetID).Scan(&count)
+	return count, err
+}
+
+// Queue returns one page of the snippets with open reports, the most reported
+// first, along with the total number of them.
+func (m *ReportModel) Queue(page, pageSize int) ([]*ReportedSnippet, int, error) {
+	var total int
+
+	err := m.DB.QueryRow("SELECT COUNT(DISTINCT snippet_id) FROM reports WHERE status = 'open'").Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	stmt := `SELECT r.snippet_id, s.title, s.hidden, COUNT(*),
+	GROUP_CONCAT(DISTINCT r.reason ORDER BY r.reason SEPARATOR ','), MAX(r.created)
+	FROM reports r JOIN snippets s ON s.id = r.snippet_id
+	WHERE r.status = 'open'
+	GROUP BY r.snippet_id, s.title, s.hidden
+	ORDER BY COUNT(*) DESC, MAX(r.created) DESC LIMIT ? OFFSET ?`
+
+	rows, err := m.DB.Query(stmt, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	queue := []*ReportedSnippet{}
+
+	for rows.Next() {
+		rs := &ReportedSnippet{}
+		var reasons string
+
+		err = rows.Scan(&rs.SnippetID, &rs.Title, &rs.Hidden, &rs.Reports, &reasons, &rs.LastReported)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		for _, reason := range strings.Split(reasons, ",") {
+			rs.Reasons = append(rs.Reasons, ReportReason(reason))
+		}
+		queue = append(queue, rs)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return queue, total, nil
+}
+
+// Close marks all of a snippet's open reports as resolved or dismissed by the
+// given moderator. It returns ErrNoRecord if the snippet has no open reports.
+func (m *ReportModel) Close(snippetID, moderatorID int, status ReportStatus) error {
+	if status != ReportResolved && status != ReportDismissed {
+		return fmt.Errorf("models: can't close reports as %q", status)
+	}
+
+	stmt := `UPDATE reports SET status = ?, closed_by = ?, closed = UTC_TIMESTAMP()
+	WHERE snippet_id = ? AND status = 'open'`
+
+	result, err := m.DB.Exec(stmt, string(status), moderatorID, snippetID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
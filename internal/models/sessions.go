@@ -0,0 +1,153 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+)
+
+type UserSessionModelInterface interface {
+	Insert(userID int, ip, userAgent string, expiry time.Time) (string, error)
+	Get(id string) (*UserSession, error)
+	Touch(id, ip string) error
+	AllForUser(userID int) ([]*UserSession, error)
+	Delete(userID int, id string) error
+	DeleteAllForUser(userID int) error
+}
+
+// UserSession holds the details of one logged-in session, so that users can
+// see where they're logged in and end sessions they don't recognize. The
+// session data itself is kept by the session manager; this is only a record
+// of it, and deleting the record is what revokes the session.
+type UserSession struct {
+	ID        string
+	UserID    int
+	Created   time.Time
+	LastSeen  time.Time
+	Expiry    time.Time
+	IP        string
+	UserAgent string
+}
+
+// Define a UserSessionModel type which wraps a database connection pool.
+type UserSessionModel struct {
+	DB *sql.DB
+}
+
+// Insert records a new session for the user and returns its ID. Records for
+// the user's sessions which have already expired are tidied up at the same
+// time.
+func (m *UserSessionModel) Insert(userID int, ip, userAgent string, expiry time.Time) (string, error) {
+	// The ID isn't a secret (it's never sent to the browser in a cookie), but
+	// it must be unique and hard to guess, so use 128 random bits.
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	id := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	// Truncate the user agent so that it fits in the column.
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
+	_, err := m.DB.Exec("DELETE FROM user_sessions WHERE user_id = ? AND expiry < UTC_TIMESTAMP()", userID)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO user_sessions (id, user_id, created, last_seen, expiry, ip, user_agent)
+	VALUES(?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP(), ?, ?, ?)`
+
+	_, err = m.DB.Exec(stmt, id, userID, expiry.UTC(), ip, userAgent)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// Get returns the session record with the given ID. If it has been revoked or
+// has expired, we return ErrNoRecord.
+func (m *UserSessionModel) Get(id string) (*UserSession, error) {
+	stmt := `SELECT id, user_id, created, last_seen, expiry, ip, user_agent FROM user_sessions
+	WHERE id = ? AND expiry > UTC_TIMESTAMP()`
+
+	s := &UserSession{}
+	err := m.DB.QueryRow(stmt, id).Scan(&s.ID, &s.UserID, &s.Created, &s.LastSeen, &s.Expiry, &s.IP, &s.UserAgent)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Touch updates the time that a session was last seen, and the IP address it
+// was last seen from.
+func (m *UserSessionModel) Touch(id, ip string) error {
+	stmt := "UPDATE user_sessions SET last_seen = UTC_TIMESTAMP(), ip = ? WHERE id = ?"
+
+	_, err := m.DB.Exec(stmt, ip, id)
+	return err
+}
+
+// AllForUser returns all of a user's unexpired sessions, most recently used
+// first.
+func (m *UserSessionModel) AllForUser(userID int) ([]*UserSession, error) {
+	stmt := `SELECT id, user_id, created, last_seen, expiry, ip, user_agent FROM user_sessions
+	WHERE user_id = ? AND expiry > UTC_TIMESTAMP() ORDER BY last_seen DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*UserSession{}
+
+	for rows.Next() {
+		s := &UserSession{}
+		err = rows.Scan(&s.ID, &s.UserID, &s.Created, &s.LastSeen, &s.Expiry, &s.IP, &s.UserAgent)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Delete revokes one of a user's sessions. The user ID is part of the WHERE
+// clause so that nobody can revoke another user's session; if there's no
+// matching session we return ErrNoRecord.
+func (m *UserSessionModel) Delete(userID int, id string) error {
+	result, err := m.DB.Exec("DELETE FROM user_sessions WHERE user_id = ? AND id = ?", userID, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// DeleteAllForUser revokes all of a user's sessions.
+func (m *UserSessionModel) DeleteAllForUser(userID int) error {
+	_, err := m.DB.Exec("DELETE FROM user_sessions WHERE user_id = ?", userID)
+	return err
+}
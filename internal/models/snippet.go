@@ -3,45 +3,124 @@ package models
 import (
     "database/sql"
     "errors" 
+    "fmt"
+    "strings"
     "time"
 )
 
 
 type SnippetModelInterface interface {
-	Insert(title string, content string, expires int) (int, error)
+	Insert(userID int, title string, content string, expires int) (int, error)
 	Get(id int) (*Snippet, error)
 	Latest() ([]*Snippet, error)
+	DeleteAllForUser(userID int) error
+	List(search string, page, pageSize int) ([]*Snippet, int, error)
+	Counts() (*SnippetCounts, error)
+	SetHidden(id int, hidden bool, by HiddenBy) error
+	UnhideIfHiddenBy(id int, by HiddenBy) error
+	Delete(id int) error
+	ListForUser(userID int, status SnippetStatus, sort SnippetSort, page, pageSize int) ([]*Snippet, int, error)
+	ExtendExpiry(id, userID, days int) error
+	DeleteForUser(id, userID int) error
 }
 
 // Define a Snippet type to hold the data dfor an individual snippet. Notice how 
 // the fields of the struct correspong to the fields in our MySQL snippets table?
+// UserID and Hidden are only filled in by List() and ListForUser(), for the
+// admin pages and the user's own list. Hidden snippets are never returned by
+// Get() or Latest().
 type Snippet struct {
     ID int
     Title string
     Content string
     Created time.Time
     Expires time.Time
+    UserID int
+    Hidden bool
+    HiddenBy HiddenBy
 }
 
-// Define a SnippetModel type which wraps a sql.DB connection pool.
+// Expired reports whether the snippet's expiry time has passed.
+func (s *Snippet) Expired() bool {
+    return !time.Now().Before(s.Expires)
+}
+
+// HiddenBy records who hid a snippet, so that a moderator dismissing the
+// reports of a snippet only shows it again if the reports hid it, and not if an
+// admin or another moderator did.
+type HiddenBy string
+
+const (
+    HiddenByReports   HiddenBy = "reports"
+    HiddenByModerator HiddenBy = "moderator"
+    HiddenByAdmin     HiddenBy = "admin"
+)
+
+// SnippetStatus filters the list of a user's snippets. The zero value means
+// all of them.
+type SnippetStatus string
+
+const (
+    SnippetsAll     SnippetStatus = ""
+    SnippetsActive  SnippetStatus = "active"
+    SnippetsExpired SnippetStatus = "expired"
+)
+
+// SnippetSort is the order of the list of a user's snippets.
+type SnippetSort string
+
+const (
+    SortNewest  SnippetSort = "newest"
+    SortOldest  SnippetSort = "oldest"
+    SortExpires SnippetSort = "expires"
+    SortTitle   SnippetSort = "title"
+)
+
+// The WHERE conditions and ORDER BY clauses for each status and sort order.
+// They're pasted into the query, so only these fixed strings can ever be used.
+var (
+    snippetStatusConditions = map[SnippetStatus]string{
+        SnippetsAll:     "TRUE",
+        SnippetsActive:  "expires > UTC_TIMESTAMP()",
+        SnippetsExpired: "expires <= UTC_TIMESTAMP()",
+    }
+    snippetSortOrders = map[SnippetSort]string{
+        SortNewest:  "id DESC",
+        SortOldest:  "id ASC",
+        SortExpires: "expires ASC, id ASC",
+        SortTitle:   "title ASC, id DESC",
+    }
+)
+
+// SnippetCounts holds the numbers of snippets shown on the admin dashboard.
+type SnippetCounts struct {
+    Total  int
+    Active int
+    Hidden int
+}
+
+// Define a SnippetModel type which wraps a sql.DB connection pool. The
+// optional Stmts field holds the statements which were prepared at startup;
+// if it is nil the queries are sent to the connection pool as normal.
 type SnippetModel struct {
-    DB *sql.DB
+    DB    *sql.DB
+    Stmts *StmtCache
 }
 
-// This will insert a new snippet into the database.
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
+// This will insert a new snippet, owned by the given user, into the database.
+func (m *SnippetModel) Insert(userID int, title string, content string, expires int) (int, error) {
     // Wirte the SQL statement we want to execute. I've split it over two lines
     // for readability (which is why it's surrounded with backquotes instead
     // of normal double quotes).
-    stmt := `INSERT INTO snippets (title, content, created, expires) 
-    VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+    stmt := `INSERT INTO snippets (title, content, created, expires, user_id) 
+    VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY), ?)`
 
     // Use the Exec() method on the embedded connection pool to execute the
     // statement. The first parameter is the SQL statement, followed by the 
     // title, content and expiry values for the placeholder parameters. This
     // method returns a sql>Result type, which contains some basic
     // information about what happened when the statement was executed.
-    result, err := m.DB.Exec(stmt, title, content, expires)
+    result, err := m.DB.Exec(stmt, title, content, expires, userID)
     if err != nil {
         return 0, err
     }
@@ -60,15 +139,16 @@ func (m *SnippetModel) Insert(title string, content string, expires int) (int, e
 
 // This will return a specific snippet based on its id.
 func (m *SnippetModel) Get(id int) (*Snippet, error) {
-    // Write the SQL statement we want to execute. Again, I've split it over two lines for readability.
-    stmt := `SELECT id, title, content, created, expires FROM snippets
-    WHERE expires > UTC_TIMESTAMP() AND id = ?`
-
-    // Use the QUeryRow() method on the connection pool to execute our
-    // SQL statement, passing in the untrusted is variable as the value for the
-    // placeholder parameter. This returns a pointer to a sql.Row object which
-    // holds the result from the database.
-    row := m.DB.QueryRow(stmt, id)
+    // Use the QUeryRow() method to execute the getSnippetStmt SQL statement
+    // (using the prepared version if we have one), passing in the untrusted id
+    // variable as the value for the placeholder parameter. This returns a
+    // pointer to a sql.Row object which holds the result from the database.
+    var row *sql.Row
+    if prepared := m.Stmts.lookup(getSnippetStmt); prepared != nil {
+        row = prepared.QueryRow(id)
+    } else {
+        row = m.DB.QueryRow(getSnippetStmt, id)
+    }
 
     // Initialize a pointer to a new zeroed Snippet struct.
     s := &Snippet{}
@@ -97,14 +177,16 @@ func (m *SnippetModel) Get(id int) (*Snippet, error) {
 
 // THis will return the 10 most recent created snippets.
 func (m *SnippetModel) Latest() ([]*Snippet, error) {
-    // Write the SQL statement we want to execute.
-    stmt := `SELECT id, title, content, created, expires FROM snippets
-    WHERE expires > UTC_TIMESTAMP() ORDER BY id DESC LIMIT 10`
-
-    // Use the Query() method on the connection pool to execute our
-    // SQL statement. THis returns a sql.Rows resultset containing the result of
-    // our query.
-    rows, err := m.DB.Query(stmt)
+    // Use the Query() method to execute the latestSnippetsStmt SQL statement,
+    // again preferring the prepared version. THis returns a sql.Rows resultset
+    // containing the result of our query.
+    var rows *sql.Rows
+    var err error
+    if prepared := m.Stmts.lookup(latestSnippetsStmt); prepared != nil {
+        rows, err = prepared.Query()
+    } else {
+        rows, err = m.DB.Query(latestSnippetsStmt)
+    }
     if err != nil {
         return nil, err
     }
@@ -151,3 +233,223 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
     // If everything went OK then return the Snippets slice.
     return snippets, nil
 }
+
+// This will delete all the snippets belonging to a user, including the
+// expired ones.
+func (m *SnippetModel) DeleteAllForUser(userID int) error {
+    stmt := "DELETE FROM snippets WHERE user_id = ?"
+
+    _, err := m.DB.Exec(stmt, userID)
+    return err
+}
+
+// likePattern escapes the wildcard characters in a search string and wraps it
+// in %s, so that it can be used in a LIKE clause to find rows containing it.
+func likePattern(search string) string {
+    search = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(search)
+    return "%" + search + "%"
+}
+
+// This will return one page of all the snippets (including the expired and
+// hidden ones), newest first, for the admin pages. If search isn't empty, only
+// snippets with it in their title or content are returned. The total number
+// of matching snippets is returned too, so that the pages can be numbered.
+func (m *SnippetModel) List(search string, page, pageSize int) ([]*Snippet, int, error) {
+    pattern := likePattern(search)
+
+    var total int
+    err := m.DB.QueryRow("SELECT COUNT(*) FROM snippets WHERE title LIKE ? OR content LIKE ?", pattern, pattern).Scan(&total)
+    if err != nil {
+        return nil, 0, err
+    }
+
+    stmt := `SELECT id, title, content, created, expires, COALESCE(user_id, 0), hidden, hidden_by FROM snippets
+    WHERE title LIKE ? OR content LIKE ? ORDER BY id DESC LIMIT ? OFFSET ?`
+
+    rows, err := m.DB.Query(stmt, pattern, pattern, pageSize, (page-1)*pageSize)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer rows.Close()
+
+    snippets := []*Snippet{}
+
+    for rows.Next() {
+        s := &Snippet{}
+        err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.Hidden, &s.HiddenBy)
+        if err != nil {
+            return nil, 0, err
+        }
+        snippets = append(snippets, s)
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, 0, err
+    }
+
+    return snippets, total, nil
+}
+
+// This will count the snippets for the admin dashboard. Active snippets are
+// the ones which haven't expired and aren't hidden.
+func (m *SnippetModel) Counts() (*SnippetCounts, error) {
+    stmt := `SELECT COUNT(*),
+    COALESCE(SUM(expires > UTC_TIMESTAMP() AND NOT hidden), 0),
+    COALESCE(SUM(hidden), 0)
+    FROM snippets`
+
+    c := &SnippetCounts{}
+    err := m.DB.QueryRow(stmt).Scan(&c.Total, &c.Active, &c.Hidden)
+    if err != nil {
+        return nil, err
+    }
+
+    return c, nil
+}
+
+// This will hide a snippet (or show it again), recording who hid it. Hidden
+// snippets stay in the database, so that hiding one can be undone, but nobody
+// except admins can see them. If there's no such snippet we return
+// ErrNoRecord.
+func (m *SnippetModel) SetHidden(id int, hidden bool, by HiddenBy) error {
+    // We can't use RowsAffected() to spot a missing snippet here, because
+    // MySQL doesn't count a row as affected if it's already hidden.
+    var exists bool
+    err := m.DB.QueryRow("SELECT EXISTS(SELECT true FROM snippets WHERE id = ?)", id).Scan(&exists)
+    if err != nil {
+        return err
+    }
+    if !exists {
+        return ErrNoRecord
+    }
+
+    if !hidden {
+        by = ""
+    }
+
+    _, err = m.DB.Exec("UPDATE snippets SET hidden = ?, hidden_by = ? WHERE id = ?", hidden, string(by), id)
+    return err
+}
+
+// This will show a snippet again, but only if it was hidden by the given
+// party. Otherwise it's left as it is. If there's no such snippet we return
+// ErrNoRecord.
+func (m *SnippetModel) UnhideIfHiddenBy(id int, by HiddenBy) error {
+    var exists bool
+    err := m.DB.QueryRow("SELECT EXISTS(SELECT true FROM snippets WHERE id = ?)", id).Scan(&exists)
+    if err != nil {
+        return err
+    }
+    if !exists {
+        return ErrNoRecord
+    }
+
+    _, err = m.DB.Exec("UPDATE snippets SET hidden = FALSE, hidden_by = '' WHERE id = ? AND hidden AND hidden_by = ?", id, string(by))
+    return err
+}
+
+// This will delete a single snippet. If there's no such snippet we return
+// ErrNoRecord.
+func (m *SnippetModel) Delete(id int) error {
+    result, err := m.DB.Exec("DELETE FROM snippets WHERE id = ?", id)
+    if err != nil {
+        return err
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if rows == 0 {
+        return ErrNoRecord
+    }
+
+    return nil
+}
+
+// This will return one page of a user's own snippets, including the expired
+// and hidden ones, along with the total number of them which match the status.
+func (m *SnippetModel) ListForUser(userID int, status SnippetStatus, sort SnippetSort, page, pageSize int) ([]*Snippet, int, error) {
+    condition, ok := snippetStatusConditions[status]
+    if !ok {
+        return nil, 0, fmt.Errorf("models: unknown snippet status %q", status)
+    }
+    order, ok := snippetSortOrders[sort]
+    if !ok {
+        return nil, 0, fmt.Errorf("models: unknown snippet sort order %q", sort)
+    }
+
+    var total int
+    err := m.DB.QueryRow("SELECT COUNT(*) FROM snippets WHERE user_id = ? AND "+condition, userID).Scan(&total)
+    if err != nil {
+        return nil, 0, err
+    }
+
+    stmt := fmt.Sprintf(`SELECT id, title, content, created, expires, user_id, hidden, hidden_by FROM snippets
+    WHERE user_id = ? AND %s ORDER BY %s LIMIT ? OFFSET ?`, condition, order)
+
+    rows, err := m.DB.Query(stmt, userID, pageSize, (page-1)*pageSize)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer rows.Close()
+
+    snippets := []*Snippet{}
+
+    for rows.Next() {
+        s := &Snippet{}
+        err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.Hidden, &s.HiddenBy)
+        if err != nil {
+            return nil, 0, err
+        }
+        snippets = append(snippets, s)
+    }
+
+    if err = rows.Err(); err != nil {
+        return nil, 0, err
+    }
+
+    return snippets, total, nil
+}
+
+// This will set one of a user's snippets to expire the given number of days
+// from now. An expired snippet comes back. Like the create form, this never
+// sets the expiry more than a year ahead, however often it's called. If the
+// snippet doesn't exist or belongs to someone else we return ErrNoRecord.
+func (m *SnippetModel) ExtendExpiry(id, userID, days int) error {
+    // We can't use RowsAffected() to spot a missing snippet here, because
+    // MySQL doesn't count a row as affected if its expiry doesn't change.
+    var exists bool
+    err := m.DB.QueryRow("SELECT EXISTS(SELECT true FROM snippets WHERE id = ? AND user_id = ?)", id, userID).Scan(&exists)
+    if err != nil {
+        return err
+    }
+    if !exists {
+        return ErrNoRecord
+    }
+
+    stmt := `UPDATE snippets SET expires = DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY)
+    WHERE id = ? AND user_id = ?`
+
+    _, err = m.DB.Exec(stmt, days, id, userID)
+    return err
+}
+
+// This will delete one of a user's snippets. If the snippet doesn't exist or
+// belongs to someone else we return ErrNoRecord.
+func (m *SnippetModel) DeleteForUser(id, userID int) error {
+    result, err := m.DB.Exec("DELETE FROM snippets WHERE id = ? AND user_id = ?", id, userID)
+    if err != nil {
+        return err
+    }
+
+    rows, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if rows == 0 {
+        return ErrNoRecord
+    }
+
+    return nil
+}
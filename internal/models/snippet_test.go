@@ -0,0 +1,151 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"snippetbox.felipeacosta.net/internal/assert"
+)
+
+// newBenchmarkModels returns two SnippetModels backed by the same test
+// database: one which sends every query to the connection pool as plain SQL,
+// and one which uses the statements prepared by NewStmtCache().
+func newBenchmarkModels(b *testing.B) (plain, prepared *SnippetModel, id int) {
+	b.Helper()
+
+	// Skip the benchmark if the "-short" flag is provided, just like the
+	// integration tests.
+	if testing.Short() {
+		b.Skip("models: skipping integration benchmark")
+	}
+
+	db := newTestDB(b)
+
+	stmts, err := NewStmtCache(db)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { stmts.Close() })
+
+	plain = &SnippetModel{DB: db}
+	prepared = &SnippetModel{DB: db, Stmts: stmts}
+
+	// The setup script doesn't add any snippets, so insert one (owned by
+	// Alice) for the Get() and Latest() queries to find.
+	id, err = plain.Insert(1, "An old silent pond", "An old silent pond...", 7)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return plain, prepared, id
+}
+
+func BenchmarkSnippetModelGet(b *testing.B) {
+	plain, prepared, id := newBenchmarkModels(b)
+
+	for _, bm := range []struct {
+		name  string
+		model *SnippetModel
+	}{
+		{name: "Unprepared", model: plain},
+		{name: "Prepared", model: prepared},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := bm.model.Get(id); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSnippetModelLatest(b *testing.B) {
+	plain, prepared, _ := newBenchmarkModels(b)
+
+	for _, bm := range []struct {
+		name  string
+		model *SnippetModel
+	}{
+		{name: "Unprepared", model: plain},
+		{name: "Prepared", model: prepared},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := bm.model.Latest(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestSnippetModelListForUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	m := SnippetModel{DB: db}
+
+	// Give Alice one snippet which is still active, and one which has expired.
+	active, err := m.Insert(1, "Active", "An active snippet", 7)
+	assert.NilError(t, err)
+	expired, err := m.Insert(1, "Expired", "An expired snippet", 1)
+	assert.NilError(t, err)
+	_, err = db.Exec("UPDATE snippets SET expires = DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 DAY) WHERE id = ?", expired)
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name      string
+		userID    int
+		status    SnippetStatus
+		sort      SnippetSort
+		wantIDs   []int
+		wantTotal int
+	}{
+		{name: "All", userID: 1, status: SnippetsAll, sort: SortNewest, wantIDs: []int{expired, active}, wantTotal: 2},
+		{name: "Oldest first", userID: 1, status: SnippetsAll, sort: SortOldest, wantIDs: []int{active, expired}, wantTotal: 2},
+		{name: "Expiring soonest", userID: 1, status: SnippetsAll, sort: SortExpires, wantIDs: []int{expired, active}, wantTotal: 2},
+		{name: "Active", userID: 1, status: SnippetsActive, sort: SortNewest, wantIDs: []int{active}, wantTotal: 1},
+		{name: "Expired", userID: 1, status: SnippetsExpired, sort: SortTitle, wantIDs: []int{expired}, wantTotal: 1},
+		{name: "Other user", userID: 2, status: SnippetsAll, sort: SortNewest, wantIDs: []int{}, wantTotal: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippets, total, err := m.ListForUser(tt.userID, tt.status, tt.sort, 1, 10)
+			assert.NilError(t, err)
+			assert.Equal(t, total, tt.wantTotal)
+			assert.Equal(t, len(snippets), len(tt.wantIDs))
+			for i, s := range snippets {
+				assert.Equal(t, s.ID, tt.wantIDs[i])
+			}
+		})
+	}
+
+	// Extending the expired snippet brings it back.
+	err = m.ExtendExpiry(expired, 1, 7)
+	assert.NilError(t, err)
+	_, err = m.Get(expired)
+	assert.NilError(t, err)
+
+	// Extending it again doesn't add up: it still expires a year from now at most.
+	for i := 0; i < 3; i++ {
+		err = m.ExtendExpiry(expired, 1, 365)
+		assert.NilError(t, err)
+	}
+	s, err := m.Get(expired)
+	assert.NilError(t, err)
+	assert.Equal(t, s.Expires.Before(time.Now().AddDate(1, 0, 1)), true)
+
+	// Only the owner can extend or delete a snippet.
+	assert.Equal(t, errors.Is(m.ExtendExpiry(active, 2, 7), ErrNoRecord), true)
+	assert.Equal(t, errors.Is(m.DeleteForUser(active, 2), ErrNoRecord), true)
+
+	err = m.DeleteForUser(active, 1)
+	assert.NilError(t, err)
+	_, err = m.Get(active)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// The SQL for the hot statements is kept in package-level constants so that
+// the model methods and the statement cache are guaranteed to use exactly the
+// same query text (which is what the cache is keyed on).
+const (
+	getSnippetStmt = `SELECT id, title, content, created, expires FROM snippets
+    WHERE expires > UTC_TIMESTAMP() AND NOT hidden AND id = ?`
+
+	latestSnippetsStmt = `SELECT id, title, content, created, expires FROM snippets
+    WHERE expires > UTC_TIMESTAMP() AND NOT hidden ORDER BY id DESC LIMIT 10`
+
+	userExistsStmt = "SELECT EXISTS(SELECT true FROM users WHERE id = ?)"
+
+	userRoleStmt = "SELECT role FROM users WHERE id = ? AND NOT disabled"
+)
+
+// StmtCache holds a set of prepared statements keyed by their SQL text. The
+// statements are prepared once at startup, so the database doesn't have to
+// re-parse the SQL for the queries which are run on (almost) every request.
+// A nil *StmtCache is valid and simply means "no cached statements".
+type StmtCache struct {
+	mu    sync.RWMutex
+	stmts map[string]*sql.Stmt
+}
+
+// NewStmtCache prepares the hot statements used by the SnippetModel.Get(),
+// SnippetModel.Latest(), UserModel.Exists() and UserModel.Role() methods against the given
+// connection pool. If any statement fails to prepare, the ones which were
+// already prepared are closed again before the error is returned.
+func NewStmtCache(db *sql.DB) (*StmtCache, error) {
+	c := &StmtCache{stmts: make(map[string]*sql.Stmt)}
+
+	for _, query := range []string{getSnippetStmt, latestSnippetsStmt, userExistsStmt, userRoleStmt} {
+		stmt, err := db.Prepare(query)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.stmts[query] = stmt
+	}
+
+	return c, nil
+}
+
+// lookup returns the prepared statement for the given query, or nil if the
+// query hasn't been prepared (or the cache itself is nil).
+func (c *StmtCache) lookup(query string) *sql.Stmt {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.stmts[query]
+}
+
+// Close closes all the prepared statements in the cache. It should be called
+// when the application shuts down, before the connection pool is closed.
+func (c *StmtCache) Close() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Keep going if a statement fails to close, so that we release as many
+	// of them as possible, but report the first error we saw.
+	var firstErr error
+	for query, stmt := range c.stmts {
+		if err := stmt.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(c.stmts, query)
+	}
+
+	return firstErr
+}
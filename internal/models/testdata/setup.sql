@@ -3,7 +3,10 @@ CREATE TABLE snippets (
     title VARCHAR(100) NOT NULL,
     content TEXT NOT NULL,
     created DATETIME NOT NULL,
-    expires DATETIME NOT NULL
+    expires DATETIME NOT NULL,
+    user_id INTEGER,
+    hidden BOOLEAN NOT NULL DEFAULT FALSE,
+    hidden_by VARCHAR(20) NOT NULL DEFAULT ''
 );
 
 CREATE INDEX idx_snippets_created ON snippets(created);
@@ -13,14 +16,89 @@ CREATE TABLE users (
     name VARCHAR(255) NOT NULL,
     email VARCHAR(255) NOT NULL,
     hashed_password CHAR(60) NOT NULL, 
-    created DATETIME NOT NULL
+    created DATETIME NOT NULL,
+    activated BOOLEAN NOT NULL DEFAULT FALSE,
+    totp_secret VARCHAR(64),
+    totp_last_counter BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    role VARCHAR(20) NOT NULL DEFAULT 'user',
+    disabled BOOLEAN NOT NULL DEFAULT FALSE
 );
 
 ALTER TABLE users ADD CONSTRAINT users_uc_email UNIQUE (email);
 
-INSERT INTO users (name, email, hashed_password, created) VALUES (
+-- Snippets created before accounts existed don't have an owner, so user_id
+-- is nullable. A user's snippets are deleted along with their account.
+ALTER TABLE snippets ADD CONSTRAINT fk_snippets_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+INSERT INTO users (name, email, hashed_password, created, activated) VALUES (
     'Alice Jones',
     'alice@example.com',
     '$2a$12$NuTjWXm3KKntReFwyBVHyuf/to.HEwTy.eS206TNfkGfr6HzGJSWG',
-    '2022-01-01 10:00:00'
+    '2022-01-01 10:00:00',
+    TRUE
 );
+
+CREATE TABLE tokens (
+    hash BINARY(32) NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    expiry DATETIME NOT NULL,
+    scope VARCHAR(50) NOT NULL,
+    CONSTRAINT fk_tokens_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE recovery_codes (
+    user_id INTEGER NOT NULL,
+    hash BINARY(32) NOT NULL,
+    PRIMARY KEY (user_id, hash),
+    CONSTRAINT fk_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE user_sessions (
+    id CHAR(26) NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    last_seen DATETIME NOT NULL,
+    expiry DATETIME NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    user_agent VARCHAR(255) NOT NULL,
+    CONSTRAINT fk_user_sessions_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_sessions_user_id ON user_sessions(user_id);
+
+CREATE TABLE audit_events (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    target_type VARCHAR(20) NOT NULL,
+    target_id INTEGER NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    request_id VARCHAR(64) NOT NULL DEFAULT '',
+    created DATETIME NOT NULL
+);
+
+CREATE INDEX idx_audit_events_user_id ON audit_events(user_id);
+CREATE INDEX idx_audit_events_target ON audit_events(target_type, target_id);
+
+-- Each user can have one open report of a snippet at a time. Reports stay
+-- 'open' until a moderator resolves or dismisses them, and then the user can
+-- report the snippet again. MySQL doesn't have partial indexes, so the unique
+-- constraint is on a generated column which is NULL for closed reports (NULLs
+-- never clash with each other).
+CREATE TABLE reports (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    reason VARCHAR(20) NOT NULL,
+    details VARCHAR(500) NOT NULL DEFAULT '',
+    status VARCHAR(10) NOT NULL DEFAULT 'open',
+    created DATETIME NOT NULL,
+    closed_by INTEGER NULL,
+    closed DATETIME NULL,
+    open_user_id INTEGER AS (IF(status = 'open', user_id, NULL)) STORED,
+    CONSTRAINT reports_uc_snippet_user UNIQUE (snippet_id, open_user_id),
+    CONSTRAINT fk_reports_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE,
+    CONSTRAINT fk_reports_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_reports_status ON reports(status);
@@ -1,3 +1,13 @@
-DROP TABLE users;
+DROP TABLE reports;
+
+DROP TABLE audit_events;
+
+DROP TABLE user_sessions;
+
+DROP TABLE recovery_codes;
+
+DROP TABLE tokens;
 
 DROP TABLE snippets;
+
+DROP TABLE users;
@@ -7,7 +7,7 @@ import (
 )
 
 
-func newTestDB(t *testing.T) *sql.DB {
+func newTestDB(t testing.TB) *sql.DB {
 	// Establish a sql.DB connection pool for our test database. Because our 
 	// setup and teardown scripts contains multiple SQL statements, we need
 	// to use the "multiStatements=true" parameters in our DSN. This instructs
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+)
+
+// Define constants for the token scopes. A token can only be used for the
+// purpose it was created for, so a password reset token can't (for example)
+// be used to verify an email address.
+const (
+	ScopePasswordReset = "password-reset"
+)
+
+type TokenModelInterface interface {
+	New(userID int, ttl time.Duration, scope string) (string, error)
+	UserID(scope, plaintext string) (int, error)
+	DeleteAllForUser(scope string, userID int) error
+}
+
+// Define a TokenModel type which wraps a database connection pool. Only the
+// SHA-256 hash of each token is stored in the database, so a leaked copy of
+// the tokens table can't be used to reset anyone's password.
+type TokenModel struct {
+	DB *sql.DB
+}
+
+// generateToken returns a new random token in plain text (to be sent to the
+// user) along with its SHA-256 hash (to be stored).
+func generateToken() (string, []byte, error) {
+	// 16 bytes from the operating system's CSPRNG gives us 128 bits of
+	// entropy, which is plenty to stop anyone guessing a token.
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", nil, err
+	}
+
+	// Encode the bytes as base-32 without padding, so the token is safe to
+	// put in a URL. This gives us a 26 character string like
+	// "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU".
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	hash := sha256.Sum256([]byte(plaintext))
+	return plaintext, hash[:], nil
+}
+
+// New creates a token for the given user and scope which expires after ttl,
+// stores its hash and returns the plain-text token.
+func (m *TokenModel) New(userID int, ttl time.Duration, scope string) (string, error) {
+	plaintext, hash, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO tokens (hash, user_id, expiry, scope)
+	VALUES(?, ?, ?, ?)`
+
+	_, err = m.DB.Exec(stmt, hash, userID, time.Now().Add(ttl).UTC(), scope)
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// UserID returns the ID of the user that a token belongs to. If the token
+// doesn't exist, has expired, or was created for a different scope we return
+// ErrNoRecord.
+func (m *TokenModel) UserID(scope, plaintext string) (int, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	stmt := `SELECT user_id FROM tokens
+	WHERE hash = ? AND scope = ? AND expiry > UTC_TIMESTAMP()`
+
+	var userID int
+	err := m.DB.QueryRow(stmt, hash[:], scope).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// DeleteAllForUser deletes all of a user's tokens for the given scope. We use
+// this once a token has been used, which is what makes tokens single-use.
+func (m *TokenModel) DeleteAllForUser(scope string, userID int) error {
+	stmt := "DELETE FROM tokens WHERE scope = ? AND user_id = ?"
+
+	_, err := m.DB.Exec(stmt, scope, userID)
+	return err
+}
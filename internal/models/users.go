@@ -3,6 +3,7 @@ package models
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"strings"
 	"time"
 
@@ -12,9 +13,53 @@ import (
 
 
 type UserModelInterface interface {
-	Insert(name, email, password string) error
+	Insert(name, email, password string) (int, error)
 	Authenticate(email, password string) (int, error)
 	Exists(id int) (bool, error)
+	Get(id int) (*User, error)
+	GetByEmail(email string) (*User, error)
+	UpdatePassword(id int, password string) error
+	Activate(id int) error
+	Delete(id int) error
+	EnableTOTP(id int, secret string) error
+	DisableTOTP(id int) error
+	TOTPSecret(id int) (string, error)
+	UseTOTPCounter(id int, counter uint64) (bool, error)
+	Role(id int) (Role, error)
+	SetRole(id int, role Role) error
+	SetDisabled(id int, disabled bool) error
+	List(search string, page, pageSize int) ([]*User, int, error)
+	Counts() (*UserCounts, error)
+}
+
+// Role is what a user is allowed to do. Each role can do everything that the
+// roles below it can: moderators can do everything users can, and admins can
+// do everything moderators can.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// roleRanks orders the roles from least to most powerful. Anything which
+// isn't in here (including the empty role of a logged-out user) ranks lowest.
+var roleRanks = map[Role]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// Valid reports whether r is one of the defined roles.
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// AtLeast reports whether r has all the powers of min.
+func (r Role) AtLeast(min Role) bool {
+	return r.Valid() && roleRanks[r] >= roleRanks[min]
 }
 
 // Define a new User type. Notice how the field names and types align
@@ -25,37 +70,57 @@ type User struct {
 	Email          string
 	HashedPassword []byte
 	Created        time.Time
+	Activated      bool
+	TOTPEnabled    bool
+	Role           Role
+	Disabled       bool
 }
 
-// Define a new UserModel type which wraps a database connection pool.
+// UserCounts holds the numbers of users shown on the admin dashboard.
+type UserCounts struct {
+	Total    int
+	Disabled int
+}
+
+// Define a new UserModel type which wraps a database connection pool and
+// (optionally) the cache of statements prepared at startup.
 type UserModel struct {
-	DB *sql.DB
+	DB    *sql.DB
+	Stmts *StmtCache
 }
 
-// We'll use the Insert method to add a new record to the "users" table.
-func (m *UserModel) Insert(name, email, password string) error {
+// We'll use the Insert method to add a new record to the "users" table. New
+// users start off with activated = FALSE until they verify their email
+// address. The ID of the new user is returned.
+func (m *UserModel) Insert(name, email, password string) (int, error) {
 	// Create a bcrypt hash of the plain-text password.
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	stmt := `INSERT INTO users (name, email, hashed_password, created)
-	VALUES(?, ?, ?, UTC_TIMESTAMP())`
+	stmt := `INSERT INTO users (name, email, hashed_password, created, activated)
+	VALUES(?, ?, ?, UTC_TIMESTAMP(), FALSE)`
 
 	// Use the Exec() method to insert the user details and hashed password into the users table.
-	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
+	result, err := m.DB.Exec(stmt, name, email, string(hashedPassword))
 	if err != nil {
 		// If this returns an error, we use the erros.As() funciton to check whether the error has the type *mysql.MySQLError. If it does, the error will be assigned to the mySQLError variable. We can check wheter or not the error relates to our users_uc_email key by checking if the error code equals 1062 and the contents of the error message string. If it does, we return an ErrDuplicateEmail error.
 		var mySQLError *mysql.MySQLError
 		if errors.As(err, &mySQLError) {
 			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
-				return ErrDuplicateEmail
+				return 0, ErrDuplicateEmail
 			}
 		}
-		return err
+		return 0, err
 	}
-	return nil
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
 }
 
 // We'll use the Authenticate method to verify whether a user exists with
@@ -98,9 +163,286 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 func (m *UserModel) Exists(id int) (bool, error) {
 	var exists bool
 
-	stmt := "SELECT EXISTS(SELECT true FROM users WHERE id = ?)"
+	// Exists() is called by the authenticate middleware on every request from
+	// a logged-in user, so use the prepared statement if there is one.
+	var row *sql.Row
+	if prepared := m.Stmts.lookup(userExistsStmt); prepared != nil {
+		row = prepared.QueryRow(id)
+	} else {
+		row = m.DB.QueryRow(userExistsStmt, id)
+	}
 
-	err := m.DB.QueryRow(stmt, id).Scan(&exists)
+	err := row.Scan(&exists)
 	return exists, err
 }
 
+// We'll use the Get method to fetch the details of a specific user. The
+// hashed password is deliberately not returned. If there's no matching user
+// we return the ErrNoRecord error.
+func (m *UserModel) Get(id int) (*User, error) {
+	u := &User{}
+
+	stmt := "SELECT id, name, email, created, activated, totp_secret IS NOT NULL, role, disabled FROM users WHERE id = ?"
+
+	err := m.DB.QueryRow(stmt, id).Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Activated, &u.TOTPEnabled, &u.Role, &u.Disabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return u, nil
+}
+
+// We'll use the GetByEmail method to look up a user from their email address,
+// for example when they ask for a password reset link.
+func (m *UserModel) GetByEmail(email string) (*User, error) {
+	u := &User{}
+
+	stmt := "SELECT id, name, email, created, activated, totp_secret IS NOT NULL, role, disabled FROM users WHERE email = ?"
+
+	err := m.DB.QueryRow(stmt, email).Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Activated, &u.TOTPEnabled, &u.Role, &u.Disabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return u, nil
+}
+
+// We'll use the UpdatePassword method to replace a user's password with a
+// new one, hashed in the same way as in Insert.
+func (m *UserModel) UpdatePassword(id int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	stmt := "UPDATE users SET hashed_password = ? WHERE id = ?"
+
+	result, err := m.DB.Exec(stmt, string(hashedPassword), id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// We'll use the Activate method to mark a user's email address as verified.
+func (m *UserModel) Activate(id int) error {
+	stmt := "UPDATE users SET activated = TRUE WHERE id = ?"
+
+	_, err := m.DB.Exec(stmt, id)
+	return err
+}
+
+// We'll use the Delete method to delete a user's account. Their snippets and
+// tokens are deleted along with it by the foreign key constraints.
+func (m *UserModel) Delete(id int) error {
+	stmt := "DELETE FROM users WHERE id = ?"
+
+	result, err := m.DB.Exec(stmt, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// We'll use the EnableTOTP method to turn on two-factor authentication for a
+// user, with the given base-32 TOTP secret. The last used counter belongs to
+// the old secret, so it's reset.
+func (m *UserModel) EnableTOTP(id int, secret string) error {
+	stmt := "UPDATE users SET totp_secret = ?, totp_last_counter = 0 WHERE id = ?"
+
+	_, err := m.DB.Exec(stmt, secret, id)
+	return err
+}
+
+// We'll use the DisableTOTP method to turn two-factor authentication off again.
+func (m *UserModel) DisableTOTP(id int) error {
+	stmt := "UPDATE users SET totp_secret = NULL WHERE id = ?"
+
+	_, err := m.DB.Exec(stmt, id)
+	return err
+}
+
+// We'll use the TOTPSecret method to fetch a user's TOTP secret when they log
+// in. It returns the empty string if they haven't turned on two-factor
+// authentication, and ErrNoRecord if there's no such user.
+func (m *UserModel) TOTPSecret(id int) (string, error) {
+	var secret sql.NullString
+
+	stmt := "SELECT totp_secret FROM users WHERE id = ?"
+
+	err := m.DB.QueryRow(stmt, id).Scan(&secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		}
+		return "", err
+	}
+
+	return secret.String, nil
+}
+
+// We'll use the UseTOTPCounter method to record that the TOTP code for the
+// given counter has been used. It returns false, and records nothing, if a
+// code for the same or a later counter has already been used, so that a code
+// can't be replayed while it's still valid. Doing the check in the UPDATE
+// means two requests with the same code can't both succeed.
+func (m *UserModel) UseTOTPCounter(id int, counter uint64) (bool, error) {
+	stmt := "UPDATE users SET totp_last_counter = ? WHERE id = ? AND totp_last_counter < ?"
+
+	result, err := m.DB.Exec(stmt, counter, id, counter)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// We'll use the Role method to look up a user's role. It's called by the
+// authenticate middleware on every request from a logged-in user, so it uses
+// the prepared statement if there is one. If there's no matching user, or the
+// user has been disabled, we return the ErrNoRecord error: a disabled user has
+// no role at all, which stops them from being treated as logged in.
+func (m *UserModel) Role(id int) (Role, error) {
+	var row *sql.Row
+	if prepared := m.Stmts.lookup(userRoleStmt); prepared != nil {
+		row = prepared.QueryRow(id)
+	} else {
+		row = m.DB.QueryRow(userRoleStmt, id)
+	}
+
+	var role Role
+	err := row.Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		}
+		return "", err
+	}
+
+	return role, nil
+}
+
+// We'll use the SetRole method to change a user's role.
+func (m *UserModel) SetRole(id int, role Role) error {
+	if !role.Valid() {
+		return fmt.Errorf("models: invalid role %q", role)
+	}
+
+	stmt := "UPDATE users SET role = ? WHERE id = ?"
+
+	result, err := m.DB.Exec(stmt, role, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// We'll use the SetDisabled method to disable a user's account (or enable it
+// again). Disabled users can't log in.
+func (m *UserModel) SetDisabled(id int, disabled bool) error {
+	// We can't use RowsAffected() to spot a missing user here, because MySQL
+	// doesn't count a row as affected if nothing in it changed.
+	var exists bool
+	err := m.DB.QueryRow(userExistsStmt, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoRecord
+	}
+
+	_, err = m.DB.Exec("UPDATE users SET disabled = ? WHERE id = ?", disabled, id)
+	return err
+}
+
+// We'll use the List method to fetch one page of users, newest first, for the
+// admin pages. If search isn't empty, only users with it in
+// their name or email address are returned. The total number of matching users
+// is returned too, so that the pages can be numbered.
+func (m *UserModel) List(search string, page, pageSize int) ([]*User, int, error) {
+	pattern := likePattern(search)
+
+	var total int
+	err := m.DB.QueryRow("SELECT COUNT(*) FROM users WHERE name LIKE ? OR email LIKE ?", pattern, pattern).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	stmt := `SELECT id, name, email, created, activated, totp_secret IS NOT NULL, role, disabled FROM users
+	WHERE name LIKE ? OR email LIKE ? ORDER BY id DESC LIMIT ? OFFSET ?`
+
+	rows, err := m.DB.Query(stmt, pattern, pattern, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		u := &User{}
+		err = rows.Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Activated, &u.TOTPEnabled, &u.Role, &u.Disabled)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+// We'll use the Counts method to count the users for the admin dashboard.
+func (m *UserModel) Counts() (*UserCounts, error) {
+	c := &UserCounts{}
+
+	err := m.DB.QueryRow("SELECT COUNT(*), COALESCE(SUM(disabled), 0) FROM users").Scan(&c.Total, &c.Disabled)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
@@ -44,7 +44,7 @@ func TestUserModelExists(t *testing.T) {
 			db := newTestDB(t)
 
 			// Create a new instance of the UserModel.
-			m := UserModel{db}
+			m := UserModel{DB: db}
 
 			// Call the UserModel.Exists() method and check that the return 
 			// value and error match the expected values for the sub-test.
@@ -55,3 +55,35 @@ func TestUserModelExists(t *testing.T) {
 		})
 	}
 }
+
+func TestUserModelUseTOTPCounter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("models: skipping integration test")
+	}
+
+	db := newTestDB(t)
+	m := UserModel{DB: db}
+
+	// Each counter can only be used once, and never after a later one.
+	for _, tt := range []struct {
+		counter uint64
+		want    bool
+	}{
+		{counter: 100, want: true},
+		{counter: 100, want: false},
+		{counter: 99, want: false},
+		{counter: 101, want: true},
+	} {
+		ok, err := m.UseTOTPCounter(1, tt.counter)
+		assert.NilError(t, err)
+		assert.Equal(t, ok, tt.want)
+	}
+
+	// Turning two-factor authentication on again starts afresh.
+	err := m.EnableTOTP(1, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+	assert.NilError(t, err)
+
+	ok, err := m.UseTOTPCounter(1, 50)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+}
@@ -0,0 +1,244 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.felipeacosta.net/internal/assert"
+	"snippetbox.felipeacosta.net/internal/oidc"
+	"snippetbox.felipeacosta.net/internal/oidc/oidctest"
+)
+
+// newProvider starts a stub identity provider and returns a Provider for it.
+func newProvider(t *testing.T) (*oidc.Provider, *oidctest.Server) {
+	idp := oidctest.NewServer(t)
+
+	p, err := oidc.Discover(context.Background(), idp.Client(), idp.URL)
+	assert.NilError(t, err)
+
+	p.ClientID = oidctest.ClientID
+	p.ClientSecret = oidctest.ClientSecret
+	p.RedirectURL = "https://localhost:4000/user/login/oidc/callback"
+
+	return p, idp
+}
+
+func TestDiscover(t *testing.T) {
+	p, idp := newProvider(t)
+
+	assert.Equal(t, p.Issuer, idp.URL)
+	assert.Equal(t, p.AuthURL, idp.URL+"/authorize")
+	assert.Equal(t, p.TokenURL, idp.URL+"/token")
+	assert.Equal(t, p.JWKSURL, idp.URL+"/jwks")
+
+	// A discovery document for a different issuer must be rejected.
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"issuer":"https://evil.example.com","authorization_endpoint":"a","token_endpoint":"b","jwks_uri":"c"}`))
+	}))
+	defer other.Close()
+
+	_, err := oidc.Discover(context.Background(), other.Client(), other.URL)
+	if err == nil || !strings.Contains(err.Error(), "issuer mismatch") {
+		t.Errorf("got %v; want issuer mismatch error", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	p, idp := newProvider(t)
+
+	now := time.Now()
+	valid := func() map[string]any {
+		return map[string]any{
+			"iss":            idp.URL,
+			"sub":            "1234",
+			"aud":            oidctest.ClientID,
+			"exp":            now.Add(time.Hour).Unix(),
+			"nonce":          "the-nonce",
+			"email":          "alice@example.com",
+			"email_verified": true,
+			"name":           "Alice Jones",
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(claims map[string]any)
+		nonce  string
+		valid  bool
+	}{
+		{
+			name:   "Valid",
+			modify: func(claims map[string]any) {},
+			nonce:  "the-nonce",
+			valid:  true,
+		},
+		{
+			name:   "Audience array",
+			modify: func(claims map[string]any) { claims["aud"] = []string{"other", oidctest.ClientID} },
+			nonce:  "the-nonce",
+			valid:  true,
+		},
+		{
+			name:   "String email_verified",
+			modify: func(claims map[string]any) { claims["email_verified"] = "true" },
+			nonce:  "the-nonce",
+			valid:  true,
+		},
+		{
+			name:   "Wrong audience",
+			modify: func(claims map[string]any) { claims["aud"] = "someone-else" },
+			nonce:  "the-nonce",
+		},
+		{
+			name:   "Wrong issuer",
+			modify: func(claims map[string]any) { claims["iss"] = "https://evil.example.com" },
+			nonce:  "the-nonce",
+		},
+		{
+			name:   "Expired",
+			modify: func(claims map[string]any) { claims["exp"] = now.Add(-2 * time.Minute).Unix() },
+			nonce:  "the-nonce",
+		},
+		{
+			name:   "Within clock skew",
+			modify: func(claims map[string]any) { claims["exp"] = now.Add(-30 * time.Second).Unix() },
+			nonce:  "the-nonce",
+			valid:  true,
+		},
+		{
+			name:   "Wrong nonce",
+			modify: func(claims map[string]any) {},
+			nonce:  "another-nonce",
+		},
+		{
+			name:   "No subject",
+			modify: func(claims map[string]any) { delete(claims, "sub") },
+			nonce:  "the-nonce",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.modify(claims)
+
+			token, err := idp.Sign(claims)
+			assert.NilError(t, err)
+
+			got, err := p.Verify(context.Background(), token, tt.nonce)
+			if !tt.valid {
+				assert.Equal(t, errors.Is(err, oidc.ErrInvalidToken), true)
+				return
+			}
+
+			assert.NilError(t, err)
+			assert.Equal(t, *got, oidc.Claims{
+				Subject:       "1234",
+				Email:         "alice@example.com",
+				EmailVerified: true,
+				Name:          "Alice Jones",
+			})
+		})
+	}
+
+	t.Run("Unsigned", func(t *testing.T) {
+		token, err := idp.Sign(valid())
+		assert.NilError(t, err)
+
+		parts := strings.Split(token, ".")
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+		_, err = p.Verify(context.Background(), header+"."+parts[1]+".", "the-nonce")
+		assert.Equal(t, errors.Is(err, oidc.ErrInvalidToken), true)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		token, err := idp.Sign(valid())
+		assert.NilError(t, err)
+
+		claims := valid()
+		claims["email"] = "mallory@example.com"
+		forged, err := idp.Sign(claims)
+		assert.NilError(t, err)
+
+		// Put the forged claims with the original signature.
+		parts := strings.Split(token, ".")
+		forgedParts := strings.Split(forged, ".")
+		_, err = p.Verify(context.Background(), parts[0]+"."+forgedParts[1]+"."+parts[2], "the-nonce")
+		assert.Equal(t, errors.Is(err, oidc.ErrInvalidToken), true)
+	})
+}
+
+func TestExchange(t *testing.T) {
+	p, idp := newProvider(t)
+
+	// authorize follows the authorization URL to the stub provider, and
+	// returns the code which it sends back to the redirect URL.
+	authorize := func(t *testing.T, state, nonce, verifier string) string {
+		client := idp.Client()
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+
+		rs, err := client.Get(p.AuthCodeURL(state, nonce, verifier))
+		assert.NilError(t, err)
+		rs.Body.Close()
+		assert.Equal(t, rs.StatusCode, http.StatusFound)
+
+		location, err := url.Parse(rs.Header.Get("Location"))
+		assert.NilError(t, err)
+		assert.Equal(t, location.Scheme+"://"+location.Host+location.Path, p.RedirectURL)
+		assert.Equal(t, location.Query().Get("state"), state)
+
+		return location.Query().Get("code")
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		code := authorize(t, "the-state", "the-nonce", "the-verifier")
+
+		claims, err := p.Exchange(context.Background(), code, "the-verifier", "the-nonce")
+		assert.NilError(t, err)
+		assert.Equal(t, claims.Email, "alice@example.com")
+		assert.Equal(t, claims.EmailVerified, true)
+
+		// Codes can only be used once.
+		_, err = p.Exchange(context.Background(), code, "the-verifier", "the-nonce")
+		if err == nil {
+			t.Error("expected an error when reusing a code")
+		}
+	})
+
+	t.Run("Wrong code verifier", func(t *testing.T) {
+		code := authorize(t, "the-state", "the-nonce", "the-verifier")
+
+		_, err := p.Exchange(context.Background(), code, "another-verifier", "the-nonce")
+		if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+			t.Errorf("got %v; want invalid_grant error", err)
+		}
+	})
+
+	t.Run("Wrong client secret", func(t *testing.T) {
+		code := authorize(t, "the-state", "the-nonce", "the-verifier")
+
+		bad := &oidc.Provider{
+			Issuer:       p.Issuer,
+			TokenURL:     p.TokenURL,
+			JWKSURL:      p.JWKSURL,
+			ClientID:     p.ClientID,
+			ClientSecret: "wrong",
+			RedirectURL:  p.RedirectURL,
+			Client:       p.Client,
+		}
+		_, err := bad.Exchange(context.Background(), code, "the-verifier", "the-nonce")
+		if err == nil || !strings.Contains(err.Error(), "invalid_client") {
+			t.Errorf("got %v; want invalid_client error", err)
+		}
+	})
+}
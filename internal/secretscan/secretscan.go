@@ -0,0 +1,241 @@
+// Package secretscan looks for credentials, like cloud provider keys and
+// private keys, in text. It's used to warn people who are about to publish a
+// snippet with a secret in it. It can't find every secret, and it's meant to
+// catch mistakes, not to stop anyone who really wants to publish one.
+package secretscan
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A Rule describes one kind of secret.
+type Rule struct {
+	// Name identifies the rule, for example in the -secret-scan flag.
+	Name string
+	// Description says what was found, and is shown to the user.
+	Description string
+	// Pattern matches the secret.
+	Pattern *regexp.Regexp
+	// If MinEntropy is set, a match only counts if its Shannon entropy (in
+	// bits per character) is at least this much. That stops long but
+	// predictable strings, like "aaaa..." or words joined together, from
+	// being mistaken for random tokens.
+	MinEntropy float64
+}
+
+// A Finding is one match of a rule in the scanned text.
+type Finding struct {
+	Rule        string
+	Description string
+	// Line is the line number (starting at 1) where the match starts.
+	Line int
+	// start and end are the byte offsets of the match.
+	start, end int
+}
+
+// DefaultRules returns the built-in rules, the most specific first. Each call
+// returns a new slice, so the caller can change it.
+func DefaultRules() []Rule {
+	return []Rule{
+		{
+			Name:        "pem-private-key",
+			Description: "a private key",
+			// Match the whole block, so that the lines of the key aren't
+			// reported again as high-entropy tokens.
+			Pattern: regexp.MustCompile(`(?s)-----BEGIN[A-Z0-9 ]* PRIVATE KEY-----.*?(?:-----END[A-Z0-9 ]* PRIVATE KEY-----|\z)`),
+		},
+		{
+			Name:        "aws-access-key",
+			Description: "an AWS access key ID",
+			Pattern:     regexp.MustCompile(`\b(?:AKIA|ASIA|AROA|AIDA)[0-9A-Z]{16}\b`),
+		},
+		{
+			Name:        "aws-secret-key",
+			Description: "an AWS secret access key",
+			Pattern:     regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?[A-Za-z0-9/+]{40}\b`),
+		},
+		{
+			Name:        "gcp-api-key",
+			Description: "a Google Cloud API key",
+			Pattern:     regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`),
+		},
+		{
+			Name:        "azure-storage-key",
+			Description: "an Azure storage account key",
+			Pattern:     regexp.MustCompile(`AccountKey=[A-Za-z0-9+/]{86}==`),
+		},
+		{
+			Name:        "github-token",
+			Description: "a GitHub token",
+			Pattern:     regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{70,})\b`),
+		},
+		{
+			Name:        "slack-token",
+			Description: "a Slack token",
+			Pattern:     regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`),
+		},
+		{
+			Name:        "stripe-key",
+			Description: "a Stripe secret key",
+			Pattern:     regexp.MustCompile(`\b[rs]k_live_[A-Za-z0-9]{24,}\b`),
+		},
+		{
+			Name:        "jwt",
+			Description: "a JSON Web Token",
+			Pattern:     regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`),
+		},
+		{
+			Name:        "high-entropy",
+			Description: "a random-looking token",
+			// Tokens of at least 32 characters from the base-64 alphabets.
+			// Hex strings (like git commit hashes) have at most 4 bits of
+			// entropy per character, so they're never reported.
+			Pattern:    regexp.MustCompile(`[A-Za-z0-9+/_-]{32,}={0,2}`),
+			MinEntropy: 4.3,
+		},
+	}
+}
+
+// Select returns the rules with the given names, in the order they appear in
+// rules. It's an error to name a rule which doesn't exist.
+func Select(rules []Rule, names []string) ([]Rule, error) {
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var selected []Rule
+	for _, rule := range rules {
+		if wanted[rule.Name] {
+			selected = append(selected, rule)
+			delete(wanted, rule.Name)
+		}
+	}
+
+	if len(wanted) > 0 {
+		var unknown []string
+		for name := range wanted {
+			unknown = append(unknown, name)
+		}
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("secretscan: unknown rules: %s", strings.Join(unknown, ", "))
+	}
+
+	return selected, nil
+}
+
+// ParseRules reads extra rules, one per line, in the form "name pattern". The
+// pattern is a Go regular expression, and it's the rest of the line after the
+// name and the whitespace which follows it. Blank lines and lines starting
+// with "#" are ignored. Rules read like this use their name as their
+// description.
+func ParseRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, pattern, ok := strings.Cut(line, " ")
+		pattern = strings.TrimSpace(pattern)
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("secretscan: line %d: want \"name pattern\"", n)
+		}
+
+		rx, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("secretscan: line %d: %w", n, err)
+		}
+
+		rules = append(rules, Rule{Name: name, Description: name, Pattern: rx})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// Scanner checks text against a list of rules.
+type Scanner struct {
+	rules []Rule
+}
+
+// New returns a Scanner which uses the given rules. The rules are tried in
+// order, and a match which overlaps one that's already been found is
+// ignored, so the more specific rules should come first.
+func New(rules []Rule) *Scanner {
+	return &Scanner{rules: rules}
+}
+
+// Scan returns everything in text which matches one of the rules, in the
+// order they appear in the text.
+func (s *Scanner) Scan(text string) []Finding {
+	var findings []Finding
+
+	for _, rule := range s.rules {
+		for _, loc := range rule.Pattern.FindAllStringIndex(text, -1) {
+			start, end := loc[0], loc[1]
+			if start == end || overlaps(findings, start, end) {
+				continue
+			}
+			if rule.MinEntropy > 0 && Entropy(text[start:end]) < rule.MinEntropy {
+				continue
+			}
+
+			findings = append(findings, Finding{
+				Rule:        rule.Name,
+				Description: rule.Description,
+				Line:        strings.Count(text[:start], "\n") + 1,
+				start:       start,
+				end:         end,
+			})
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool { return findings[i].start < findings[j].start })
+	return findings
+}
+
+func overlaps(findings []Finding, start, end int) bool {
+	for _, f := range findings {
+		if start < f.end && f.start < end {
+			return true
+		}
+	}
+	return false
+}
+
+// Entropy returns the Shannon entropy of s in bits per character. It can't be
+// more than log2(len(s)), but long random base-64 strings have close to 6,
+// random hex close to 4, and English text about 4.
+func Entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+
+	counts := map[rune]int{}
+	total := 0
+	for _, r := range s {
+		counts[r]++
+		total++
+	}
+
+	var entropy float64
+	for _, count := range counts {
+		p := float64(count) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+
+	return entropy
+}
@@ -0,0 +1,134 @@
+// Package totp implements the time-based one-time passwords from RFC 6238, as
+// used by authenticator apps like Google Authenticator and 1Password. Only the
+// settings which every app supports are used: HMAC-SHA1, 6 digits and a 30
+// second period.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits is the number of digits in each code.
+	Digits = 6
+	// Period is how long each code is valid for.
+	Period = 30 * time.Second
+	// Skew is the number of periods either side of the current one which are
+	// also accepted, to allow for clock drift and slow typists.
+	Skew = 1
+)
+
+// encoding is the base-32 encoding which authenticator apps expect secrets in.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random secret, base-32 encoded. 20 bytes (160
+// bits) is the length recommended by RFC 4226.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// counter returns the number of periods between the Unix epoch and t.
+func counter(t time.Time) uint64 {
+	return uint64(t.Unix()) / uint64(Period/time.Second)
+}
+
+// code computes the HOTP value (RFC 4226) for the given counter.
+func code(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation: the low 4 bits of the last byte pick which 4 bytes
+	// of the hash to use.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, value%1000000)
+}
+
+// decodeSecret decodes a base-32 secret. Authenticator apps show secrets in
+// groups with spaces, and people type them in lower case, so both are allowed.
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	return encoding.DecodeString(strings.TrimRight(secret, "="))
+}
+
+// Code returns the code for secret at time t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, counter(t)), nil
+}
+
+// Validate reports whether passcode is the right code for secret at time t,
+// or within Skew periods of it.
+func Validate(secret, passcode string, t time.Time) bool {
+	_, ok := Match(secret, passcode, t)
+	return ok
+}
+
+// Match is like Validate, but it also returns the counter (the number of
+// periods since the Unix epoch) which the passcode was for. Each code is
+// accepted for several periods, so callers should store the counter of the
+// last code they accepted and refuse any code whose counter isn't newer,
+// otherwise a code that has been seen by someone else can be used again.
+func Match(secret, passcode string, t time.Time) (uint64, bool) {
+	passcode = strings.ReplaceAll(strings.TrimSpace(passcode), " ", "")
+	if len(passcode) != Digits {
+		return 0, false
+	}
+
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	now := counter(t)
+	for i := -Skew; i <= Skew; i++ {
+		// Use a constant time comparison, so that the response time doesn't
+		// give away how many digits were right.
+		if subtle.ConstantTimeCompare([]byte(code(key, now+uint64(i))), []byte(passcode)) == 1 {
+			return now + uint64(i), true
+		}
+	}
+
+	return 0, false
+}
+
+// URI returns the otpauth:// URI for the secret, which is what goes in the QR
+// code that authenticator apps scan. The issuer and account name are shown in
+// the app's list of accounts.
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period/time.Second)))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
@@ -0,0 +1,114 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.felipeacosta.net/internal/assert"
+)
+
+// rfcSecret is the SHA-1 test secret from RFC 6238, "12345678901234567890",
+// base-32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// The test vectors from appendix B of RFC 6238. The RFC gives 8 digit
+	// codes, so these are the last 6 digits of each.
+	tests := []struct {
+		name string
+		unix int64
+		want string
+	}{
+		{name: "59", unix: 59, want: "287082"},
+		{name: "1111111109", unix: 1111111109, want: "081804"},
+		{name: "1111111111", unix: 1111111111, want: "050471"},
+		{name: "1234567890", unix: 1234567890, want: "005924"},
+		{name: "2000000000", unix: 2000000000, want: "279037"},
+		{name: "20000000000", unix: 20000000000, want: "353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+			assert.NilError(t, err)
+			assert.Equal(t, code, tt.want)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	tests := []struct {
+		name     string
+		secret   string
+		passcode string
+		want     bool
+	}{
+		{name: "Current code", secret: rfcSecret, passcode: "005924", want: true},
+		{name: "Spaces", secret: rfcSecret, passcode: " 005 924 ", want: true},
+		{name: "Lower case secret", secret: strings.ToLower(rfcSecret), passcode: "005924", want: true},
+		{name: "Wrong code", secret: rfcSecret, passcode: "123456", want: false},
+		{name: "Too short", secret: rfcSecret, passcode: "05924", want: false},
+		{name: "Empty", secret: rfcSecret, passcode: "", want: false},
+		{name: "Invalid secret", secret: "not base32!", passcode: "005924", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, Validate(tt.secret, tt.passcode, now), tt.want)
+		})
+	}
+
+	// Codes from one period either side are accepted, but not from further away.
+	for _, tt := range []struct {
+		offset time.Duration
+		want   bool
+	}{
+		{offset: -Period, want: true},
+		{offset: Period, want: true},
+		{offset: -2 * Period, want: false},
+		{offset: 2 * Period, want: false},
+	} {
+		code, err := Code(rfcSecret, now.Add(tt.offset))
+		assert.NilError(t, err)
+		assert.Equal(t, Validate(rfcSecret, code, now), tt.want)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	// Each code gives back the counter it was for, so that it can't be used
+	// again once a newer one has been.
+	for _, offset := range []time.Duration{-Period, 0, Period} {
+		code, err := Code(rfcSecret, now.Add(offset))
+		assert.NilError(t, err)
+
+		got, ok := Match(rfcSecret, code, now)
+		assert.Equal(t, ok, true)
+		assert.Equal(t, got, counter(now.Add(offset)))
+	}
+
+	_, ok := Match(rfcSecret, "123456", now)
+	assert.Equal(t, ok, false)
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NilError(t, err)
+	assert.Equal(t, len(secret), 32)
+
+	code, err := Code(secret, time.Now())
+	assert.NilError(t, err)
+	assert.Equal(t, Validate(secret, code, time.Now()), true)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Snippetbox", "alice@example.com", rfcSecret)
+
+	assert.StringContains(t, uri, "otpauth://totp/Snippetbox:alice@example.com?")
+	assert.StringContains(t, uri, "secret="+rfcSecret)
+	assert.StringContains(t, uri, "issuer=Snippetbox")
+}
@@ -0,0 +1,9 @@
+-- Password reset tokens. Only a hash of each token is stored, and they're
+-- deleted along with the user.
+CREATE TABLE tokens (
+    hash BINARY(32) NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    expiry DATETIME NOT NULL,
+    scope VARCHAR(50) NOT NULL,
+    CONSTRAINT fk_tokens_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
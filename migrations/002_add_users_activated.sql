@@ -0,0 +1,6 @@
+-- Users have to verify their email address before they can create snippets.
+-- New accounts start out unverified, but everyone who signed up before
+-- verification existed is treated as verified, so that they aren't locked out.
+ALTER TABLE users ADD COLUMN activated BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE users SET activated = TRUE;
@@ -0,0 +1,6 @@
+-- Snippets belong to the user who created them. Snippets created before
+-- accounts existed don't have an owner, so user_id is nullable and left NULL
+-- for them. A user's snippets are deleted along with their account.
+ALTER TABLE snippets ADD COLUMN user_id INTEGER;
+
+ALTER TABLE snippets ADD CONSTRAINT fk_snippets_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
@@ -0,0 +1,14 @@
+-- Two-factor authentication. totp_secret is NULL for users who haven't turned
+-- it on, and totp_last_counter is the time step of the last code they used,
+-- so that a code can't be used twice.
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64);
+ALTER TABLE users ADD COLUMN totp_last_counter BIGINT UNSIGNED NOT NULL DEFAULT 0;
+
+-- Hashes of the one-time recovery codes, for when the user loses their
+-- authenticator app.
+CREATE TABLE recovery_codes (
+    user_id INTEGER NOT NULL,
+    hash BINARY(32) NOT NULL,
+    PRIMARY KEY (user_id, hash),
+    CONSTRAINT fk_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,15 @@
+-- A record of each logged-in session, so that users can see where they're
+-- logged in and revoke sessions. Existing logins don't have a record, so
+-- they're logged out once this is applied.
+CREATE TABLE user_sessions (
+    id CHAR(26) NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    last_seen DATETIME NOT NULL,
+    expiry DATETIME NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    user_agent VARCHAR(255) NOT NULL,
+    CONSTRAINT fk_user_sessions_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_sessions_user_id ON user_sessions(user_id);
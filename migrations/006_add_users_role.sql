@@ -0,0 +1,3 @@
+-- Every existing user is a plain user. Use the -admin-email flag to make
+-- someone an admin, and then the admin users page to make moderators.
+ALTER TABLE users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user';
@@ -0,0 +1,15 @@
+-- Admins can disable users and hide snippets. Nobody is disabled and nothing
+-- is hidden to begin with.
+ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE snippets ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- A log of what admins have done.
+CREATE TABLE audit_events (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    target_type VARCHAR(20) NOT NULL,
+    target_id INTEGER NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    created DATETIME NOT NULL
+);
@@ -0,0 +1,29 @@
+-- Who (or what) hid a snippet, so that dismissing its reports only shows it
+-- again if it was hidden automatically because of them. Snippets which an
+-- admin has already hidden are marked as hidden by an admin.
+ALTER TABLE snippets ADD COLUMN hidden_by VARCHAR(20) NOT NULL DEFAULT '';
+
+UPDATE snippets SET hidden_by = 'admin' WHERE hidden;
+
+-- Each user can have one open report of a snippet at a time. Reports stay
+-- 'open' until a moderator resolves or dismisses them, and then the user can
+-- report the snippet again. MySQL doesn't have partial indexes, so the unique
+-- constraint is on a generated column which is NULL for closed reports (NULLs
+-- never clash with each other).
+CREATE TABLE reports (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    reason VARCHAR(20) NOT NULL,
+    details VARCHAR(500) NOT NULL DEFAULT '',
+    status VARCHAR(10) NOT NULL DEFAULT 'open',
+    created DATETIME NOT NULL,
+    closed_by INTEGER NULL,
+    closed DATETIME NULL,
+    open_user_id INTEGER AS (IF(status = 'open', user_id, NULL)) STORED,
+    CONSTRAINT reports_uc_snippet_user UNIQUE (snippet_id, open_user_id),
+    CONSTRAINT fk_reports_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE,
+    CONSTRAINT fk_reports_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_reports_status ON reports(status);
@@ -0,0 +1,6 @@
+-- The audit log now records security events for every user, and which
+-- request each one came from. Events logged before this have no request ID.
+ALTER TABLE audit_events ADD COLUMN request_id VARCHAR(64) NOT NULL DEFAULT '' AFTER ip;
+
+CREATE INDEX idx_audit_events_user_id ON audit_events(user_id);
+CREATE INDEX idx_audit_events_target ON audit_events(target_type, target_id);